@@ -0,0 +1,137 @@
+package namesilo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccountStatus summarizes the health of the namesilo account behind the
+// API token.
+type AccountStatus struct {
+	// Domains is the number of domains in the account.
+	Domains int
+
+	// NearestExpiration is the earliest expiration date across the
+	// account's domains, and NearestExpiringDomain the domain it belongs
+	// to. Both are zero if namesilo didn't report expiration dates.
+	NearestExpiration     time.Time
+	NearestExpiringDomain string
+
+	// Balance is the account's funds, in USD.
+	Balance float64
+}
+
+// Balance is the amount of funds in a namesilo account.
+type Balance struct {
+	Amount float64
+
+	// Currency is the ISO 4217 code of Amount. namesilo keeps balances
+	// in US dollars and doesn't state the currency in its replies, so it
+	// is always "USD".
+	Currency string
+}
+
+// GetAccountBalance returns the account's funds, which should be
+// monitored so that domains don't fail to renew.
+func (p *Provider) GetAccountBalance(ctx context.Context) (Balance, error) {
+	p.logf("GetAccountBalance")
+
+	amount, err := p.accountBalance(ctx)
+	if err != nil {
+		return Balance{}, err
+	}
+	return Balance{Amount: amount, Currency: "USD"}, nil
+}
+
+type domainInfo struct {
+	Name    string
+	Expires time.Time
+}
+
+// AccountStatus confirms the API token works and returns a summary of the
+// account's domains and balance.
+func (p *Provider) AccountStatus(ctx context.Context) (AccountStatus, error) {
+	p.logf("AccountStatus")
+
+	domains, err := p.listDomains(ctx)
+	if err != nil {
+		return AccountStatus{}, err
+	}
+
+	balance, err := p.accountBalance(ctx)
+	if err != nil {
+		return AccountStatus{}, err
+	}
+
+	status := AccountStatus{
+		Domains: len(domains),
+		Balance: balance,
+	}
+	for _, domain := range domains {
+		if domain.Expires.IsZero() {
+			continue
+		}
+		if status.NearestExpiration.IsZero() || domain.Expires.Before(status.NearestExpiration) {
+			status.NearestExpiration = domain.Expires
+			status.NearestExpiringDomain = domain.Name
+		}
+	}
+
+	return status, nil
+}
+
+// listDomains lists the domains in the account.
+func (p *Provider) listDomains(ctx context.Context) ([]domainInfo, error) {
+	var reply struct {
+		apiReply
+		Domains []struct {
+			Name    string `xml:",chardata"`
+			Expires string `xml:"expires,attr"`
+		} `xml:"reply>domains>domain"`
+	}
+
+	if err := p.call(ctx, "listDomains", nil, &reply); err != nil {
+		return nil, err
+	}
+
+	if err := reply.err("", ""); err != nil {
+		return nil, err
+	}
+
+	var domains []domainInfo
+	for _, domain := range reply.Domains {
+		info := domainInfo{Name: strings.TrimSpace(domain.Name)}
+		if domain.Expires != "" {
+			expires, err := time.Parse("2006-01-02", domain.Expires)
+			if err != nil {
+				p.debugf("listDomains: could not parse expiration %q of %s: %v", domain.Expires, info.Name, err)
+			}
+			info.Expires = expires
+		}
+		domains = append(domains, info)
+	}
+
+	return domains, nil
+}
+
+// accountBalance returns the account's funds as reported by
+// getAccountBalance.
+func (p *Provider) accountBalance(ctx context.Context) (float64, error) {
+	var reply struct {
+		apiReply
+		Balance string `xml:"reply>balance"`
+	}
+
+	if err := p.call(ctx, "getAccountBalance", nil, &reply); err != nil {
+		return 0, err
+	}
+
+	if err := reply.err("", ""); err != nil {
+		return 0, err
+	}
+
+	// namesilo formats the balance with thousands separators.
+	return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(reply.Balance), ",", ""), 64)
+}
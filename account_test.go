@@ -0,0 +1,78 @@
+package namesilo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAccountStatus(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("listDomains", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "listDomains", codeSuccess, "success", `<domains>`+
+			`<domain created="2020-01-01" expires="2027-03-01">example.com</domain>`+
+			`<domain created="2021-06-01" expires="2026-11-15">example.net</domain>`+
+			`<domain>example.org</domain>`+
+			`</domains>`)
+	})
+	f.handle("getAccountBalance", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "getAccountBalance", codeSuccess, "success", `<balance>1,234.56</balance>`)
+	})
+
+	status, err := f.provider().AccountStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AccountStatus{
+		Domains:               3,
+		NearestExpiration:     time.Date(2026, 11, 15, 0, 0, 0, 0, time.UTC),
+		NearestExpiringDomain: "example.net",
+		Balance:               1234.56,
+	}
+	if status != want {
+		t.Errorf("AccountStatus = %+v, want %+v", status, want)
+	}
+}
+
+func TestAccountStatusInvalidKey(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("listDomains", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "listDomains", codeInvalidAPIKey, "Invalid API Key", "")
+	})
+
+	if _, err := f.provider().AccountStatus(context.Background()); err == nil {
+		t.Fatal("AccountStatus succeeded with an invalid key")
+	}
+	if n := f.count("getAccountBalance"); n != 0 {
+		t.Errorf("fetched the balance %d times after listDomains failed", n)
+	}
+}
+
+func TestGetAccountBalance(t *testing.T) {
+	tests := []struct {
+		balance string
+		want    float64
+		ok      bool
+	}{
+		{"42.50", 42.5, true},
+		{" 12,345.67 ", 12345.67, true},
+		{"", 0, false},
+		{"n/a", 0, false},
+	}
+	for _, tt := range tests {
+		f := newFakeNamesilo(t)
+		f.handle("getAccountBalance", func(w http.ResponseWriter, r *http.Request) {
+			writeReply(w, "getAccountBalance", codeSuccess, "success", "<balance>"+tt.balance+"</balance>")
+		})
+
+		balance, err := f.provider().GetAccountBalance(context.Background())
+		if (err == nil) != tt.ok {
+			t.Errorf("balance %q: GetAccountBalance returned error %v, want success %v", tt.balance, err, tt.ok)
+			continue
+		}
+		if tt.ok && (balance.Amount != tt.want || balance.Currency != "USD") {
+			t.Errorf("balance %q: GetAccountBalance = %+v, want %v USD", tt.balance, balance, tt.want)
+		}
+	}
+}
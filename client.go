@@ -0,0 +1,406 @@
+package namesilo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// requestCounterKey is the context key for the *requestCounter installed
+// by CountRequests.
+type requestCounterKey struct{}
+
+type requestCounter struct {
+	n      int64
+	parent *requestCounter
+}
+
+// CountRequests returns a context that counts the namesilo API requests
+// made with it, and a function reporting the count so far. Counting
+// contexts may be nested; each counts the requests made beneath it.
+func CountRequests(ctx context.Context) (context.Context, func() int) {
+	parent, _ := ctx.Value(requestCounterKey{}).(*requestCounter)
+	counter := &requestCounter{parent: parent}
+	return context.WithValue(ctx, requestCounterKey{}, counter), func() int {
+		return int(atomic.LoadInt64(&counter.n))
+	}
+}
+
+func countRequest(ctx context.Context) {
+	counter, _ := ctx.Value(requestCounterKey{}).(*requestCounter)
+	for ; counter != nil; counter = counter.parent {
+		atomic.AddInt64(&counter.n, 1)
+	}
+}
+
+// traceIDKey is the context key for the ID set by WithTraceID.
+type traceIDKey struct{}
+
+// WithTraceID returns a context whose API requests carry id in the
+// provider's TraceHeader, for correlating them with the caller's traces.
+// The ID is also included in log lines and in the errors returned.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+func traceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+func (p *Provider) traceHeader() string {
+	if p.TraceHeader != "" {
+		return p.TraceHeader
+	}
+	return "X-Request-ID"
+}
+
+// clientMu guards the lazy initialization of Provider.client.
+var clientMu sync.Mutex
+
+// httpClient returns the client used for API requests, creating it on
+// first use. Copies of a Provider made afterwards share it.
+func (p *Provider) httpClient() *http.Client {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	if p.client == nil {
+		p.client = &http.Client{Transport: p.newTransport()}
+	}
+	return p.client
+}
+
+// rateLimiter returns the limiter pacing requests: RateLimiter if set, or
+// else one enforcing HourlyRequestBudget, created on first use and, like
+// the HTTP client, shared by copies of the Provider made afterwards. It
+// returns nil if requests aren't limited.
+func (p *Provider) rateLimiter() *rate.Limiter {
+	if p.RateLimiter != nil {
+		return p.RateLimiter
+	}
+
+	clientMu.Lock()
+	defer clientMu.Unlock()
+
+	if p.limiter == nil && p.HourlyRequestBudget > 0 {
+		p.limiter = rate.NewLimiter(rate.Every(time.Hour/time.Duration(p.HourlyRequestBudget)), 1)
+	}
+	return p.limiter
+}
+
+// WithToken returns a copy of the provider that authenticates with token,
+// for managing domains of several namesilo accounts from one process. The
+// copy shares the provider's HTTP client and its connections, and its
+// request budget.
+func (p *Provider) WithToken(token string) *Provider {
+	p.httpClient()
+	p.rateLimiter()
+
+	clone := *p
+	clone.APIToken = token
+	return &clone
+}
+
+// newTransport builds the transport for API requests. Like Go's default
+// transport, it routes requests through the proxy configured in the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func (p *Provider) newTransport() *http.Transport {
+	var transport *http.Transport
+	if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = defaultTransport.Clone()
+	} else {
+		// Another package replaced http.DefaultTransport; start from
+		// the settings Go's own default uses instead.
+		transport = &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if p.TLSConfig != nil {
+		transport.TLSClientConfig = p.TLSConfig.Clone()
+	}
+
+	if p.DialNetwork != "" {
+		network := p.DialNetwork
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return transport
+}
+
+// apiReply holds the fields shared by namesilo API responses. Operations
+// that return more embed it in their own reply struct.
+//
+// Reply struct tags name elements without a namespace, which encoding/xml
+// matches by local name alone, so replies parse the same should namesilo
+// ever declare XML namespaces. Keep namespaces out of the tags.
+type apiReply struct {
+	Operation string `xml:"request>operation"`
+	RequestIP string `xml:"request>ip"`
+	Code      int    `xml:"reply>code"`
+	Detail    string `xml:"reply>detail"`
+
+	// RecordID is set by operations that create or modify a record.
+	RecordID string `xml:"reply>record_id"`
+
+	// Warnings holds any warnings namesilo attached to the reply.
+	Warnings []string `xml:"reply>warning"`
+
+	traceID string
+}
+
+// err returns an *APIError describing the reply, or nil if the reply
+// indicates success.
+func (r *apiReply) err(domain, record string) error {
+	if r.Code == codeSuccess {
+		return nil
+	}
+	return &APIError{
+		Operation: r.Operation,
+		RequestIP: r.RequestIP,
+		Domain:    domain,
+		Record:    record,
+		Code:      r.Code,
+		Detail:    r.Detail,
+		Warnings:  r.Warnings,
+		TraceID:   r.traceID,
+	}
+}
+
+func (r *apiReply) replyWarnings() []string {
+	return r.Warnings
+}
+
+func (r *apiReply) replyCode() int {
+	return r.Code
+}
+
+func (r *apiReply) replyDetail() string {
+	return r.Detail
+}
+
+func (r *apiReply) setTraceID(id string) {
+	r.traceID = id
+}
+
+// defaultMaxURLLength is used when Provider.MaxURLLength is zero.
+const defaultMaxURLLength = 8 << 10
+
+func (p *Provider) maxURLLength() int {
+	if p.MaxURLLength > 0 {
+		return p.MaxURLLength
+	}
+	return defaultMaxURLLength
+}
+
+// maxResponseSize caps how much of a response body is decoded, guarding
+// against runaway responses.
+const maxResponseSize = 64 << 20
+
+// call invokes the namesilo API operation with params and decodes the XML
+// response into v, retrying transient failures up to MaxRetries times.
+func (p *Provider) call(ctx context.Context, operation string, params url.Values, v interface{}) error {
+	_, err := p.retryCall(ctx, operation, params, v, nil)
+	return err
+}
+
+// retryCall is call for operations that aren't safe to repeat. A request
+// that failed in transit may still have been carried out, so before each
+// retry applied is asked whether it was; if so, retryCall stops there and
+// returns true.
+func (p *Provider) retryCall(ctx context.Context, operation string, params url.Values, v interface{}, applied func() (bool, error)) (bool, error) {
+	if p.APIToken == "" {
+		return false, fmt.Errorf("%s: %w", operation, ErrMissingToken)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && applied != nil {
+			if ok, err := applied(); err != nil || ok {
+				return ok, err
+			}
+		}
+
+		if attempt > 0 {
+			// Decoding appends to slices, so start from a clean reply.
+			resetReply(v)
+		}
+
+		err := p.callOnce(ctx, operation, params, v)
+		reason := p.retryReason(err, v)
+		if reason == nil || attempt >= p.MaxRetries {
+			return false, err
+		}
+
+		delay := backoff(attempt, retryBaseDelay, retryMaxDelay)
+		p.logf("%s: retrying in %v after: %v", logOperation(ctx, operation), delay.Round(time.Millisecond), reason)
+		if err := p.wait(ctx, delay); err != nil {
+			return false, err
+		}
+	}
+}
+
+// callOnce sends a single request for call.
+func (p *Provider) callOnce(ctx context.Context, operation string, params url.Values, v interface{}) error {
+	query := url.Values{}
+	query.Set("version", "1")
+	query.Set("type", apiFormat.param)
+
+	if p.ClientTrace != nil {
+		if trace := p.ClientTrace(operation); trace != nil {
+			ctx = httptrace.WithClientTrace(ctx, trace)
+		}
+	}
+
+	var req *http.Request
+	var err error
+	if p.UsePOST {
+		// The key goes in the body with the other parameters, where
+		// namesilo reads it like the query string, so that it stays out
+		// of URLs in logs.
+		body := url.Values{"key": {p.APIToken}}
+		for k, vs := range params {
+			body[k] = vs
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", p.getApiHost()+"/"+operation+"?"+query.Encode(), strings.NewReader(body.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query.Set("key", p.APIToken)
+		for k, vs := range params {
+			query[k] = vs
+		}
+		reqURL := p.getApiHost() + "/" + operation + "?" + query.Encode()
+		if maxLen := p.maxURLLength(); len(reqURL) > maxLen {
+			return fmt.Errorf("%s request URL is %d bytes, over the %d byte limit; enable UsePOST or split long TXT values into several records",
+				operation, len(reqURL), maxLen)
+		}
+		req, err = http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	id := traceID(ctx)
+	if id != "" {
+		req.Header.Set(p.traceHeader(), id)
+	}
+
+	if limiter := p.rateLimiter(); limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	countRequest(ctx)
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		// The error quotes the request URL, which holds the API key.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = p.redactToken(urlErr.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		record := params.Get("rrid")
+		if record == "" {
+			record = params.Get("rrhost")
+		}
+		httpErr := p.newHTTPError(resp.StatusCode, bodyBytes, params.Get("domain"), record)
+		httpErr.TraceID = id
+		// Error pages sometimes still carry a namesilo reply.
+		var reply apiReply
+		if apiFormat.decode(bytes.NewReader(bodyBytes), &reply) == nil {
+			httpErr.ReplyCode = reply.Code
+			httpErr.ReplyDetail = reply.Detail
+		}
+		return httpErr
+	}
+
+	if err := checkContentType(resp); err != nil {
+		return err
+	}
+
+	// Decode straight from the body rather than buffering it, so large
+	// zones aren't held in memory twice.
+	if err := apiFormat.decode(io.LimitReader(resp.Body, maxResponseSize), v); err == io.EOF {
+		return fmt.Errorf("%s: %w", operation, ErrEmptyResponse)
+	} else if err != nil {
+		return fmt.Errorf("could not decode %s reply: %w", operation, err)
+	}
+
+	if reply, ok := v.(interface{ setTraceID(string) }); ok {
+		reply.setTraceID(id)
+	}
+
+	// Warnings come with successful replies too, where nothing else would
+	// surface them.
+	if reply, ok := v.(interface{ replyWarnings() []string }); ok {
+		for _, warning := range reply.replyWarnings() {
+			p.logf("%s: warning: %s", logOperation(ctx, operation), strings.TrimSpace(warning))
+		}
+	}
+
+	return nil
+}
+
+// logOperation labels log lines about operation with the context's trace
+// ID, if any.
+func logOperation(ctx context.Context, operation string) string {
+	if id := traceID(ctx); id != "" {
+		return operation + " [trace " + id + "]"
+	}
+	return operation
+}
+
+// checkContentType rejects responses that clearly aren't in the API's
+// format, such as an HTML page from a captive portal, before they reach
+// the decoder.
+func checkContentType(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && apiFormat.accepts(mediaType) {
+		return nil
+	}
+
+	snippet, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 200))
+	return fmt.Errorf("unexpected response content type %q (expected %s); Body: %s", contentType, apiFormat.name, string(snippet))
+}
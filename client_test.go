@@ -0,0 +1,633 @@
+package namesilo
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"golang.org/x/time/rate"
+)
+
+func TestNonXMLResponse(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><body>Please log in to the hotel network</body></html>"))
+	})
+
+	_, err := f.provider().GetRecords(context.Background(), testZone)
+	if err == nil {
+		t.Fatal("GetRecords succeeded on an HTML response")
+	}
+	for _, want := range []string{`"text/html; charset=utf-8"`, "hotel network"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "XML syntax") {
+		t.Errorf("error %q comes from the XML decoder", err)
+	}
+}
+
+func TestXMLContentTypes(t *testing.T) {
+	for _, contentType := range []string{"text/xml", "application/xml; charset=UTF-8", "text/plain", ""} {
+		t.Run(contentType, func(t *testing.T) {
+			f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+			f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{contentType}
+				w.Write([]byte(`<namesilo><reply><code>300</code><detail>success</detail>` +
+					recordXML(nsRecord("1", "A", "www", "192.0.2.1")) + `</reply></namesilo>`))
+			})
+
+			records, err := f.provider().GetRecords(context.Background(), testZone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Errorf("got %d records, want 1", len(records))
+			}
+		})
+	}
+}
+
+func TestAPIReplyVariants(t *testing.T) {
+	const request = `<request><operation>op</operation><ip>127.0.0.1</ip></request>`
+	tests := []struct {
+		name string
+		body string
+		want apiReply
+	}{
+		{
+			"success",
+			`<reply><code>300</code><detail>success</detail></reply>`,
+			apiReply{Code: codeSuccess, Detail: "success"},
+		},
+		{
+			"record ID",
+			`<reply><code>300</code><detail>success</detail><record_id>abc123</record_id></reply>`,
+			apiReply{Code: codeSuccess, Detail: "success", RecordID: "abc123"},
+		},
+		{
+			"failure",
+			`<reply><code>280</code><detail>DNS modification error</detail></reply>`,
+			apiReply{Code: codeDNSModification, Detail: "DNS modification error"},
+		},
+		{
+			"warnings",
+			`<reply><code>300</code><detail>success</detail><warning>one</warning><warning>two</warning></reply>`,
+			apiReply{Code: codeSuccess, Detail: "success", Warnings: []string{"one", "two"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reply apiReply
+			if err := xml.Unmarshal([]byte("<namesilo>"+request+tt.body+"</namesilo>"), &reply); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Operation, tt.want.RequestIP = "op", "127.0.0.1"
+			if reply.Code != tt.want.Code || reply.Detail != tt.want.Detail || reply.RecordID != tt.want.RecordID ||
+				reply.Operation != tt.want.Operation || reply.RequestIP != tt.want.RequestIP ||
+				strings.Join(reply.Warnings, ",") != strings.Join(tt.want.Warnings, ",") {
+				t.Errorf("decoded %+v, want %+v", reply, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIReplyEmbedded(t *testing.T) {
+	var reply struct {
+		apiReply
+		Balance string `xml:"reply>balance"`
+	}
+	body := `<namesilo><reply><code>300</code><detail>success</detail><balance>10.00</balance></reply></namesilo>`
+	if err := xml.Unmarshal([]byte(body), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != codeSuccess || reply.Balance != "10.00" {
+		t.Errorf("decoded code %d and balance %q", reply.Code, reply.Balance)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	// ProxyFromEnvironment reads the environment once per process, so the
+	// test runs in a child process of its own.
+	if os.Getenv("NAMESILO_TEST_PROXY_CHILD") != "1" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestProxyFromEnvironment$", "-test.v")
+		cmd.Env = append(os.Environ(), "NAMESILO_TEST_PROXY_CHILD=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("child process failed: %v\n%s", err, out)
+		}
+		if !strings.Contains(string(out), "--- PASS: TestProxyFromEnvironment") {
+			t.Fatalf("child process didn't run the test:\n%s", out)
+		}
+		return
+	}
+
+	// The fake API acts as the proxy, receiving requests for a host that
+	// doesn't resolve. Loopback hosts would bypass the proxy.
+	f := newFakeNamesilo(t)
+	t.Setenv("HTTP_PROXY", f.server.URL)
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", "")
+	p := f.provider()
+	p.apiHost = "http://api.namesilo.invalid/api"
+
+	if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+		t.Fatal(err)
+	}
+	requests := f.received("dnsListRecords")
+	if len(requests) != 1 || requests[0].URL.Host != "api.namesilo.invalid" {
+		t.Errorf("proxy received %v, want the request for api.namesilo.invalid", requests)
+	}
+}
+
+func TestNewTransportWithReplacedDefault(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = http.NewFileTransport(http.Dir("."))
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	transport := (&Provider{}).newTransport()
+	if transport.Proxy == nil || transport.DialContext == nil {
+		t.Errorf("transport %+v lacks a proxy or dialer", transport)
+	}
+}
+
+func TestDialNetwork(t *testing.T) {
+	f := newFakeNamesilo(t)
+	addr := f.server.Listener.Addr().String() // an IPv4 loopback address
+
+	p := f.provider()
+	p.DialNetwork = "tcp4"
+	if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+		t.Fatalf("request over tcp4: %v", err)
+	}
+
+	// The dialer ignores the network the transport asks for, so an IPv6
+	// dialer can't reach the IPv4 server even when asked for "tcp".
+	transport := (&Provider{DialNetwork: "tcp6"}).newTransport()
+	conn, err := transport.DialContext(context.Background(), "tcp", addr)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("tcp6 dialer connected to %s", addr)
+	}
+	var addrErr *net.AddrError
+	if !errors.As(err, &addrErr) {
+		t.Errorf("tcp6 dial to %s failed with %v, want an address family error", addr, err)
+	}
+}
+
+// listingFixture returns a dnsListRecords reply holding n records.
+func listingFixture(n int) []byte {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><namesilo><request><operation>dnsListRecords</operation><ip>127.0.0.1</ip></request><reply><code>300</code><detail>success</detail>`)
+	for i := 0; i < n; i++ {
+		b.WriteString(recordXML(nsRecord(strconv.Itoa(i), "TXT", "host"+strconv.Itoa(i), strings.Repeat("v", 64))))
+	}
+	b.WriteString(`</reply></namesilo>`)
+	return []byte(b.String())
+}
+
+// BenchmarkDecodeListing compares reading a large listing into memory
+// before decoding it, as replies once were, with decoding it as it streams
+// in.
+func BenchmarkDecodeListing(b *testing.B) {
+	body := listingFixture(5000)
+	type listing struct {
+		apiReply
+		Records []NamesiloRecord `xml:"reply>resource_record"`
+	}
+	// Hide bytes.Reader's WriteTo so that reads behave like a response body.
+	newBody := func() io.Reader { return struct{ io.Reader }{bytes.NewReader(body)} }
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := ioutil.ReadAll(newBody())
+			if err != nil {
+				b.Fatal(err)
+			}
+			var reply listing
+			if err := xml.Unmarshal(data, &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var reply listing
+			if err := apiFormat.decode(io.LimitReader(newBody(), maxResponseSize), &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("sink", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			reply := recordStream{Records: recordSink{
+				ctx: context.Background(),
+				fn:  func(NamesiloRecord) error { return nil },
+			}}
+			if err := apiFormat.decode(io.LimitReader(newBody(), maxResponseSize), &reply); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestUsePOST(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	p.UsePOST = true
+
+	const value = "a-secret-challenge-token"
+	if _, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: value}}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := f.received("dnsAddRecord")[0]
+	if req.Method != http.MethodPost {
+		t.Errorf("sent with method %s, want POST", req.Method)
+	}
+	if got := req.Params.Get("rrvalue"); got != value {
+		t.Errorf("body carries rrvalue %q, want %q", got, value)
+	}
+	query := req.URL.Query()
+	for _, name := range []string{"rrvalue", "rrhost", "domain"} {
+		if _, ok := query[name]; ok {
+			t.Errorf("URL %s carries %s", req.URL, name)
+		}
+	}
+	if strings.Contains(req.URL.String(), value) {
+		t.Errorf("URL %s carries the record value", req.URL)
+	}
+	if strings.Contains(req.URL.RawQuery, "key=") || strings.Contains(req.URL.String(), testToken) {
+		t.Errorf("URL %s carries the API key", req.URL)
+	}
+	if got := req.Params.Get("key"); got != testToken {
+		t.Errorf("body carries key %q, want the API key", got)
+	}
+}
+
+func TestMaxURLLength(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+
+	_, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: "long", Value: strings.Repeat("x", 9000)}})
+	if err == nil || !strings.Contains(err.Error(), "enable UsePOST") {
+		t.Errorf("oversized request returned %v, want the URL length error", err)
+	}
+	if n := f.count(""); n != 0 {
+		t.Errorf("made %d requests, want none", n)
+	}
+
+	p.UsePOST = true
+	if _, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: "long", Value: strings.Repeat("x", 9000)}}); err != nil {
+		t.Errorf("oversized value over POST: %v", err)
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	p.APIToken = "first-token"
+	p.HourlyRequestBudget = 3600 * 1000
+	other := p.WithToken(testToken)
+
+	if _, err := p.GetRecords(context.Background(), testZone); err == nil {
+		t.Error("GetRecords succeeded with the first account's token")
+	}
+	if _, err := other.GetRecords(context.Background(), testZone); err != nil {
+		t.Errorf("GetRecords with the second token: %v", err)
+	}
+
+	requests := f.received("dnsListRecords")
+	if len(requests) != 2 || requests[0].Params.Get("key") != "first-token" || requests[1].Params.Get("key") != testToken {
+		t.Errorf("requests sent keys %v, want each provider's own", requests)
+	}
+	if p.httpClient() != other.httpClient() {
+		t.Error("the copy has its own HTTP client")
+	}
+	if p.rateLimiter() != other.rateLimiter() {
+		t.Error("the copy has its own rate limiter")
+	}
+	if p.APIToken != "first-token" {
+		t.Errorf("WithToken changed the original's token to %q", p.APIToken)
+	}
+}
+
+func TestNamespacedReply(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<?xml version="1.0"?>
+<ns:namesilo xmlns:ns="urn:namesilo:api" xmlns="urn:namesilo:default">
+<ns:request><ns:operation>dnsListRecords</ns:operation></ns:request>
+<reply><code>300</code><ns:detail>success</ns:detail>
+<ns:resource_record><record_id>1</record_id><type>A</type><ns:host>www.example.com</ns:host><value>192.0.2.1</value><ttl>3600</ttl><distance>0</distance></ns:resource_record>
+</reply></ns:namesilo>`))
+	})
+
+	records, err := f.provider().GetRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "1" || records[0].Value != "192.0.2.1" || records[0].Name != "www.example.com" {
+		t.Errorf("GetRecords = %+v, want the namespaced record", records)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsAddRecord", codeDNSModification, "Invalid value", "")
+	})
+	p := f.provider()
+	p.TraceHeader = "X-Trace"
+	ctx := WithTraceID(context.Background(), "trace-123")
+
+	if _, err := p.GetRecords(ctx, testZone); err != nil {
+		t.Fatal(err)
+	}
+	_, err := p.AppendRecords(ctx, testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}})
+	if err == nil || !strings.Contains(err.Error(), "trace-123") {
+		t.Errorf("error %v lacks the trace ID", err)
+	}
+
+	for _, req := range f.received("") {
+		if got := req.Header.Get("X-Trace"); got != "trace-123" {
+			t.Errorf("%s sent with X-Trace %q, want trace-123", req.Operation, got)
+		}
+		if got := req.Header.Get("X-Request-ID"); got != "" {
+			t.Errorf("%s sent with X-Request-ID %q", req.Operation, got)
+		}
+	}
+
+	if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+		t.Fatal(err)
+	}
+	if requests := f.received("dnsListRecords"); requests[len(requests)-1].Header.Get("X-Trace") != "" {
+		t.Error("request without a trace ID sent the header")
+	}
+}
+
+func TestHourlyRequestBudget(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	// One request every 50ms.
+	p.HourlyRequestBudget = int(time.Hour / (50 * time.Millisecond))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 requests took %v, want them paced 50ms apart", elapsed)
+	}
+
+	// The budget is spent, so the next request has to wait, and gives up
+	// without being sent once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetRecords(ctx, testZone); err == nil {
+		t.Error("GetRecords succeeded without waiting for the budget")
+	}
+	if n := f.count(""); n != 3 {
+		t.Errorf("server received %d requests, want 3", n)
+	}
+}
+
+func TestClientTrace(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+
+	var mu sync.Mutex
+	var operations []string
+	p.ClientTrace = func(operation string) *httptrace.ClientTrace {
+		return &httptrace.ClientTrace{
+			GotFirstResponseByte: func() {
+				mu.Lock()
+				defer mu.Unlock()
+				operations = append(operations, operation)
+			},
+		}
+	}
+	callerTraced := 0
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			callerTraced++
+		},
+	})
+
+	if _, err := p.AppendRecords(ctx, testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(operations) != 1 || operations[0] != "dnsAddRecord" {
+		t.Errorf("traced operations %v, want [dnsAddRecord]", operations)
+	}
+	if callerTraced != 1 {
+		t.Errorf("the caller's trace saw %d responses, want 1", callerTraced)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	f := newFakeNamesilo(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(f.serveHTTP))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	tests := []struct {
+		name   string
+		config *tls.Config
+		ok     bool
+	}{
+		{"default", nil, false},
+		{"trusted root", &tls.Config{RootCAs: roots}, true},
+		{"insecure", &tls.Config{InsecureSkipVerify: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := f.provider()
+			p.apiHost = server.URL
+			p.TLSConfig = tt.config
+
+			_, err := p.GetRecords(context.Background(), testZone)
+			if (err == nil) != tt.ok {
+				t.Errorf("GetRecords returned %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReplyCharsets(t *testing.T) {
+	tests := []struct {
+		encoding string
+		value    string // as sent, in encoding
+		want     string
+	}{
+		{"UTF-8", "caf\xc3\xa9", "café"},
+		{"ISO-8859-1", "caf\xe9", "café"},
+		{"windows-1252", "\x93caf\xe9\x94 \x80", "“café” €"},
+		{"KOI8-R", "\xd0\xd2\xc9\xd7\xc5\xd4", "привет"},
+		{"Shift_JIS", "\x93\xfa\x96\x7b", "日本"},
+		{"x-unknown", "caf\xe9", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				io.WriteString(w, `<?xml version="1.0" encoding="`+tt.encoding+`"?>
+<namesilo><request><operation>dnsListRecords</operation></request><reply><code>300</code><detail>success</detail>`+
+					`<resource_record><record_id>1</record_id><type>TXT</type><host>note.example.com</host><value>`+tt.value+`</value><ttl>3600</ttl><distance>0</distance></resource_record>`+
+					`</reply></namesilo>`)
+			})
+
+			records, err := f.provider().GetRecords(context.Background(), testZone)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("GetRecords decoded a reply in %s", tt.encoding)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 || records[0].Value != tt.want {
+				t.Errorf("GetRecords = %+v, want value %q", records, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	f := newFakeNamesilo(t)
+	limiter := rate.NewLimiter(rate.Every(50*time.Millisecond), 1)
+	first, second := f.provider(), f.provider()
+	first.RateLimiter = limiter
+	second.RateLimiter = limiter
+	// RateLimiter replaces the budget, which would allow far more.
+	second.HourlyRequestBudget = 1 << 30
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, p := range []*Provider{first, second} {
+		wg.Add(1)
+		go func(p *Provider) {
+			defer wg.Done()
+			for i := 0; i < 2; i++ {
+				if _, err := p.GetRecords(context.Background(), testZone); err != nil {
+					t.Error(err)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("4 requests from two providers took %v, want them paced 50ms apart", elapsed)
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	for _, body := range []string{"", " \n"} {
+		f := newFakeNamesilo(t)
+		f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/xml")
+			io.WriteString(w, body)
+		})
+
+		_, err := f.provider().GetRecords(context.Background(), testZone)
+		if !errors.Is(err, ErrEmptyResponse) {
+			t.Errorf("body %q: GetRecords returned %v, want ErrEmptyResponse", body, err)
+		}
+	}
+
+	// An empty response is retried.
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		f.handle("dnsListRecords", nil)
+		w.Header().Set("Content-Type", "text/xml")
+	})
+	p := f.provider()
+	p.MaxRetries = 1
+	p.Clock = &instantClock{}
+	records, err := p.GetRecords(context.Background(), testZone)
+	if err != nil || len(records) != 1 {
+		t.Errorf("GetRecords = %v, %v; want the record after a retry", records, err)
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	decoded := 0
+	testFormat := responseFormat{
+		param: "test",
+		name:  "test format",
+		decode: func(r io.Reader, v interface{}) error {
+			decoded++
+			return xmlFormat.decode(r, v)
+		},
+		accepts: func(mediaType string) bool {
+			return mediaType == "application/x-test"
+		},
+	}
+	saved := apiFormat
+	apiFormat = testFormat
+	t.Cleanup(func() { apiFormat = saved })
+
+	f := newFakeNamesilo(t)
+	contentType := "application/x-test"
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Form.Get("type"); got != "test" {
+			t.Errorf("request asked for type %q, want the format's parameter", got)
+		}
+		reply := httptest.NewRecorder()
+		writeReply(reply, "dnsListRecords", codeSuccess, "success", recordXML(nsRecord("1", "A", "www", "192.0.2.1")))
+		w.Header().Set("Content-Type", contentType)
+		w.Write(reply.Body.Bytes())
+	})
+
+	records, err := f.provider().GetRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || decoded != 1 {
+		t.Errorf("GetRecords = %v with %d decodes, want the record decoded by the format", records, decoded)
+	}
+
+	// A reply the format doesn't accept is refused before decoding.
+	contentType = "text/xml"
+	_, err = f.provider().GetRecords(context.Background(), testZone)
+	if err == nil || !strings.Contains(err.Error(), "expected test format") {
+		t.Errorf("GetRecords returned %v, want a content type error naming the format", err)
+	}
+	if decoded != 1 {
+		t.Errorf("decoded a reply the format doesn't accept")
+	}
+}
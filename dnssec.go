@@ -0,0 +1,87 @@
+package namesilo
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// DSRecord is a DNSSEC delegation signer record registered for a domain at
+// the registry. DS records are managed separately from the zone's
+// resource records.
+type DSRecord struct {
+	KeyTag     int
+	Algorithm  int
+	DigestType int
+	Digest     string
+}
+
+func (r DSRecord) params(domain string) url.Values {
+	return url.Values{
+		"domain":     {domain},
+		"keyTag":     {strconv.Itoa(r.KeyTag)},
+		"alg":        {strconv.Itoa(r.Algorithm)},
+		"digestType": {strconv.Itoa(r.DigestType)},
+		"digest":     {r.Digest},
+	}
+}
+
+// ListDNSSEC lists the DS records registered for the zone.
+func (p *Provider) ListDNSSEC(ctx context.Context, zone string) ([]DSRecord, error) {
+	p.logf("ListDNSSEC %s", zone)
+
+	domain := getDomain(zone)
+
+	var reply struct {
+		apiReply
+		Records []struct {
+			KeyTag     int    `xml:"key_tag"`
+			Algorithm  int    `xml:"algorithm"`
+			DigestType int    `xml:"digest_type"`
+			Digest     string `xml:"digest"`
+		} `xml:"reply>ds_record"`
+	}
+
+	if err := p.call(ctx, "dnsSecListRecords", url.Values{"domain": {domain}}, &reply); err != nil {
+		return nil, err
+	}
+
+	if err := reply.err(domain, ""); err != nil {
+		return nil, err
+	}
+
+	var records []DSRecord
+	for _, record := range reply.Records {
+		records = append(records, DSRecord{
+			KeyTag:     record.KeyTag,
+			Algorithm:  record.Algorithm,
+			DigestType: record.DigestType,
+			Digest:     record.Digest,
+		})
+	}
+
+	return records, nil
+}
+
+// AddDNSSEC registers a DS record for the zone.
+func (p *Provider) AddDNSSEC(ctx context.Context, zone string, record DSRecord) error {
+	p.logf("AddDNSSEC %s %v", zone, record)
+	return p.dnssecOperation(ctx, "dnsSecAddRecord", zone, record)
+}
+
+// DeleteDNSSEC removes a DS record from the zone.
+func (p *Provider) DeleteDNSSEC(ctx context.Context, zone string, record DSRecord) error {
+	p.logf("DeleteDNSSEC %s %v", zone, record)
+	return p.dnssecOperation(ctx, "dnsSecDeleteRecord", zone, record)
+}
+
+func (p *Provider) dnssecOperation(ctx context.Context, operation, zone string, record DSRecord) error {
+	domain := getDomain(zone)
+
+	var reply apiReply
+	if err := p.call(ctx, operation, record.params(domain), &reply); err != nil {
+		return err
+	}
+
+	return reply.err(domain, strconv.Itoa(record.KeyTag))
+}
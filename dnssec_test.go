@@ -0,0 +1,84 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestListDNSSEC(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsSecListRecords", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsSecListRecords", codeSuccess, "success",
+			`<ds_record><key_tag>12345</key_tag><algorithm>13</algorithm><digest_type>2</digest_type><digest>ABCDEF0123</digest></ds_record>`+
+				`<ds_record><key_tag>54321</key_tag><algorithm>8</algorithm><digest_type>1</digest_type><digest>0123ABCDEF</digest></ds_record>`)
+	})
+
+	records, err := f.provider().ListDNSSEC(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DSRecord{
+		{KeyTag: 12345, Algorithm: 13, DigestType: 2, Digest: "ABCDEF0123"},
+		{KeyTag: 54321, Algorithm: 8, DigestType: 1, Digest: "0123ABCDEF"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %v, want %v", records, want)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %v, want %v", i, records[i], want[i])
+		}
+	}
+}
+
+func TestAddAndDeleteDNSSEC(t *testing.T) {
+	record := DSRecord{KeyTag: 12345, Algorithm: 13, DigestType: 2, Digest: "ABCDEF0123"}
+
+	for _, operation := range []string{"dnsSecAddRecord", "dnsSecDeleteRecord"} {
+		t.Run(operation, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.handle(operation, func(w http.ResponseWriter, r *http.Request) {
+				writeReply(w, operation, codeSuccess, "success", "")
+			})
+			p := f.provider()
+
+			var err error
+			if operation == "dnsSecAddRecord" {
+				err = p.AddDNSSEC(context.Background(), testZone+".", record)
+			} else {
+				err = p.DeleteDNSSEC(context.Background(), testZone+".", record)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			params := f.received(operation)[0].Params
+			for key, want := range map[string]string{
+				"domain":     testZone,
+				"keyTag":     "12345",
+				"alg":        "13",
+				"digestType": "2",
+				"digest":     "ABCDEF0123",
+			} {
+				if got := params.Get(key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddDNSSECFailure(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsSecAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsSecAddRecord", codeDNSModification, "Invalid digest", "")
+	})
+
+	err := f.provider().AddDNSSEC(context.Background(), testZone, DSRecord{KeyTag: 1, Algorithm: 13, DigestType: 2, Digest: "zz"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Record != "1" {
+		t.Errorf("error %v, want an *APIError for key tag 1", err)
+	}
+}
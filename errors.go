@@ -0,0 +1,308 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/libdns/libdns"
+)
+
+// Reply codes from namesilo's API documentation that the package treats
+// specially.
+const (
+	codeSuccess          = 300
+	codeNoAPIKey         = 109
+	codeInvalidAPIKey    = 110
+	codeInvalidUser      = 111
+	codeSubAccount       = 112
+	codeIPNotAllowed     = 113
+	codeRegistryNotReady = 115 // central registry not responding, try again later
+	codeDomainNotActive  = 200 // domain isn't active or doesn't belong to the user
+	codeInternalError    = 201
+	codeDNSModification  = 280 // includes targeting a record that doesn't exist (yet)
+)
+
+// ErrRecordNotFound is returned when an operation targets a record that
+// doesn't exist in the zone.
+var ErrRecordNotFound = errors.New("record not found")
+
+// ErrRecordExists is returned by CreateRecords for a record that would
+// replace one already in the zone.
+var ErrRecordExists = errors.New("record already exists")
+
+// ErrDomainNotManaged matches, using errors.Is, an *APIError reporting that
+// the domain isn't active or doesn't belong to the account of the API key.
+var ErrDomainNotManaged = errors.New("domain not managed by this account")
+
+// ErrRecordLimitReached matches, using errors.Is, an *APIError reporting
+// that the zone can't hold any more records. namesilo has no dedicated
+// reply code for this and states neither the limit nor the current count,
+// so it is recognized from the reply's detail message.
+var ErrRecordLimitReached = errors.New("zone record limit reached")
+
+// ErrMissingToken is returned, before any request is sent, when the
+// Provider has no APIToken.
+var ErrMissingToken = errors.New("namesilo API token not set")
+
+// ErrEmptyResponse is returned when namesilo answers a request with an
+// empty body, as can happen during partial outages.
+var ErrEmptyResponse = errors.New("empty response from namesilo")
+
+// APIError is returned when namesilo answers a request with a reply code
+// other than success.
+type APIError struct {
+	// Operation is the API operation as echoed back by namesilo.
+	Operation string
+
+	// RequestIP is the client address namesilo recorded for the request.
+	// The API doesn't return a transaction ID, so together with Operation
+	// this is the best reference to give namesilo support.
+	RequestIP string
+
+	Domain string
+	Record string
+	Code   int
+	Detail string
+
+	// Type and Value describe the record the operation was for, if any.
+	// Value is shortened so that secrets don't end up in logs in full.
+	Type  string
+	Value string
+
+	// Warnings holds any additional warnings namesilo included in the
+	// reply.
+	Warnings []string
+
+	// TraceID is the ID set on the request's context with WithTraceID.
+	TraceID string
+}
+
+// String returns a one-line summary of the error.
+func (e *APIError) String() string {
+	return fmt.Sprintf("namesilo %s for %s: reply code %d: %s", e.Operation, e.Domain, e.Code, e.Detail)
+}
+
+// IsRetryable reports whether the failure is transient on namesilo's side,
+// so the same request may succeed later.
+func (e *APIError) IsRetryable() bool {
+	return isRetryableCode(e.Code)
+}
+
+// IsAuthError reports whether the request was rejected because of the API
+// key, the account, or the client's IP address.
+func (e *APIError) IsAuthError() bool {
+	switch e.Code {
+	case codeNoAPIKey, codeInvalidAPIKey, codeInvalidUser, codeSubAccount, codeIPNotAllowed:
+		return true
+	}
+	return false
+}
+
+// IsNotFound reports whether the domain or record the request targeted
+// doesn't exist. namesilo reports a missing record with its generic DNS
+// modification code, so other DNS failures also match.
+func (e *APIError) IsNotFound() bool {
+	switch e.Code {
+	case codeDomainNotActive, codeDNSModification:
+		return true
+	}
+	return false
+}
+
+// Is lets errors.Is match the error against ErrDomainNotManaged and
+// ErrRecordLimitReached.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrDomainNotManaged:
+		return e.Code == codeDomainNotActive
+	case ErrRecordLimitReached:
+		return e.Code == codeDNSModification && isRecordLimitDetail(e.Detail)
+	}
+	return false
+}
+
+// isRecordLimitDetail reports whether a reply detail message says that a
+// record limit was reached.
+func isRecordLimitDetail(detail string) bool {
+	detail = strings.ToLower(detail)
+	return strings.Contains(detail, "record") &&
+		(strings.Contains(detail, "limit") || strings.Contains(detail, "maximum") || strings.Contains(detail, "too many"))
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("API %s operation unsuccessful:\nDomain: %s\nRecord: %s\nReply code: %d\nDetails: %s\nRequest IP: %s",
+		e.Operation, e.Domain, describeRecord(e.Record, e.Type, e.Value), e.Code, e.Detail, e.RequestIP)
+	for _, warning := range e.Warnings {
+		msg += "\nWarning: " + strings.TrimSpace(warning)
+	}
+	if e.TraceID != "" {
+		msg += "\nTrace ID: " + e.TraceID
+	}
+	return msg
+}
+
+// HTTPError is returned when the namesilo API responds with an HTTP status
+// other than 200 OK.
+type HTTPError struct {
+	StatusCode int
+	Domain     string
+	Record     string
+
+	// Type and Value describe the record the request was for, if any,
+	// with Value shortened as in APIError.
+	Type  string
+	Value string
+
+	// Body is the beginning of the response body, with the API token
+	// redacted.
+	Body string
+
+	// ReplyCode and ReplyDetail are the namesilo reply code and detail
+	// message found in the body, if any.
+	ReplyCode   int
+	ReplyDetail string
+
+	// TraceID is the ID set on the request's context with WithTraceID.
+	TraceID string
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("HTTP error: Domain: %s; Record: %s, Status: %v; Body: %s",
+		e.Domain, describeRecord(e.Record, e.Type, e.Value), e.StatusCode, e.Body)
+	if e.TraceID != "" {
+		msg += "; Trace ID: " + e.TraceID
+	}
+	return msg
+}
+
+// newHTTPError builds an HTTPError from a non-200 response body, keeping
+// the API token out of the error text.
+func (p *Provider) newHTTPError(statusCode int, body []byte, domain, record string) *HTTPError {
+	snippet := string(body)
+	if len(snippet) > 512 {
+		snippet = snippet[:512]
+	}
+	return &HTTPError{
+		StatusCode: statusCode,
+		Domain:     domain,
+		Record:     record,
+		Body:       p.redactToken(snippet),
+	}
+}
+
+// redactToken replaces the API token in s, as written or query-escaped as
+// it appears in request URLs, with "REDACTED".
+func (p *Provider) redactToken(s string) string {
+	if p.APIToken == "" {
+		return s
+	}
+	s = strings.ReplaceAll(s, p.APIToken, "REDACTED")
+	return strings.ReplaceAll(s, url.QueryEscape(p.APIToken), "REDACTED")
+}
+
+// withRecord adds the type and a shortened value of record to err if it is
+// an *APIError or *HTTPError, so failures within a batch can be told apart.
+func withRecord(err error, record libdns.Record) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		apiErr.Type = record.Type
+		apiErr.Value = shortenValue(record.Value)
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		httpErr.Type = record.Type
+		httpErr.Value = shortenValue(record.Value)
+	}
+	return err
+}
+
+// shortenValue truncates long record values, such as ACME tokens or DKIM
+// keys, to a recognizable prefix.
+func shortenValue(value string) string {
+	if len(value) <= 16 {
+		return value
+	}
+	return value[:8] + "..."
+}
+
+func describeRecord(name, recordType, value string) string {
+	if recordType == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s %q)", name, recordType, value)
+}
+
+// BatchError is returned by batch operations run with ContinueOnError when
+// more than one record failed. It holds each record's error in turn.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d records failed:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+// Unwrap lets errors.Is and errors.As look at each record's error.
+func (e *BatchError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether any record's error matches target. Before Go 1.20,
+// errors.Is doesn't follow Unwrap() []error, so it relies on this instead.
+func (e *BatchError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first record's error that matches target, for errors.As
+// before Go 1.20 like Is.
+func (e *BatchError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// batchErrors collects the failures within a batch operation.
+type batchErrors []error
+
+// add records err, flattening a BatchError from a nested batch.
+func (errs *batchErrors) add(err error) {
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		*errs = append(*errs, batchErr.Errors...)
+		return
+	}
+	*errs = append(*errs, err)
+}
+
+// err returns the single error collected, a *BatchError holding several,
+// or nil.
+func (errs batchErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &BatchError{Errors: errs}
+}
+
+// stopBatch reports whether a batch operation should stop after a failed
+// record rather than carry on with the next.
+func (p *Provider) stopBatch(ctx context.Context) bool {
+	return !p.ContinueOnError || ctx.Err() != nil
+}
@@ -0,0 +1,217 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/libdns/libdns"
+)
+
+func TestAPIErrorEchoesRequest(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsAddRecord", codeDNSModification, "Invalid value", "")
+	})
+
+	_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error %v is not an *APIError", err)
+	}
+	if apiErr.Operation != "dnsAddRecord" || apiErr.RequestIP != "127.0.0.1" {
+		t.Errorf("Operation = %q, RequestIP = %q; want namesilo's echo of the request", apiErr.Operation, apiErr.RequestIP)
+	}
+	if apiErr.Code != codeDNSModification || apiErr.Detail != "Invalid value" {
+		t.Errorf("Code = %d, Detail = %q", apiErr.Code, apiErr.Detail)
+	}
+	if !strings.Contains(err.Error(), "Request IP: 127.0.0.1") {
+		t.Errorf("error text %q lacks the request IP", err)
+	}
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusForbidden} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				w.Write([]byte("request with key " + r.FormValue("key") + " failed"))
+			})
+
+			_, err := f.provider().GetRecords(context.Background(), testZone)
+
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("error %v is not an *HTTPError", err)
+			}
+			if httpErr.StatusCode != status {
+				t.Errorf("StatusCode = %d, want %d", httpErr.StatusCode, status)
+			}
+			if httpErr.Body != "request with key REDACTED failed" {
+				t.Errorf("Body = %q, want the token redacted", httpErr.Body)
+			}
+			if strings.Contains(err.Error(), testToken) {
+				t.Errorf("error %q leaks the API token", err)
+			}
+		})
+	}
+}
+
+func TestReplyWarnings(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsAddRecord", codeDNSModification, "Invalid value", "<warning>TTL adjusted</warning>")
+	})
+	f.handle("dnsDeleteRecord", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsDeleteRecord", codeSuccess, "success", "<warning>Record was already scheduled for removal</warning>")
+	})
+	p := f.provider()
+	logger := &captureLogger{}
+	p.Logger = logger
+
+	_, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error %v is not an *APIError", err)
+	}
+	if len(apiErr.Warnings) != 1 || apiErr.Warnings[0] != "TTL adjusted" {
+		t.Errorf("Warnings = %q, want the reply's warning", apiErr.Warnings)
+	}
+	if !strings.Contains(err.Error(), "Warning: TTL adjusted") {
+		t.Errorf("error text %q lacks the warning", err)
+	}
+
+	if err := p.DeleteRecordByID(context.Background(), testZone, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if !logger.contains("warning: Record was already scheduled for removal") {
+		t.Errorf("the warning on a successful reply wasn't logged: %q", logger.lines)
+	}
+}
+
+func TestErrorsDescribeRecord(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsAddRecord", codeDNSModification, "Invalid value", "")
+	})
+	const token = "abcdefgh-secret-remainder-of-challenge-token"
+
+	_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: token}})
+	if err == nil {
+		t.Fatal("AppendRecords succeeded")
+	}
+	if !strings.Contains(err.Error(), `_acme-challenge (TXT "abcdefgh...")`) {
+		t.Errorf("error %q lacks the record type and value snippet", err)
+	}
+	if strings.Contains(err.Error(), "secret-remainder") {
+		t.Errorf("error %q holds the full value", err)
+	}
+}
+
+func TestAPIErrorHelpers(t *testing.T) {
+	tests := []struct {
+		code                      int
+		retryable, auth, notFound bool
+	}{
+		{codeNoAPIKey, false, true, false},
+		{codeInvalidAPIKey, false, true, false},
+		{codeIPNotAllowed, false, true, false},
+		{codeRegistryNotReady, true, false, false},
+		{codeDomainNotActive, false, false, true},
+		{codeInternalError, true, false, false},
+		{codeDNSModification, false, false, true},
+		{400, false, false, false},
+	}
+	for _, tt := range tests {
+		err := &APIError{Code: tt.code}
+		if got := err.IsRetryable(); got != tt.retryable {
+			t.Errorf("code %d: IsRetryable = %v, want %v", tt.code, got, tt.retryable)
+		}
+		if got := err.IsAuthError(); got != tt.auth {
+			t.Errorf("code %d: IsAuthError = %v, want %v", tt.code, got, tt.auth)
+		}
+		if got := err.IsNotFound(); got != tt.notFound {
+			t.Errorf("code %d: IsNotFound = %v, want %v", tt.code, got, tt.notFound)
+		}
+	}
+}
+
+func TestAPIErrorString(t *testing.T) {
+	err := &APIError{Operation: "dnsAddRecord", Domain: testZone, Code: codeDNSModification, Detail: "Invalid value"}
+	want := "namesilo dnsAddRecord for example.com: reply code 280: Invalid value"
+	if got := err.String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestErrDomainNotManaged(t *testing.T) {
+	f := newFakeNamesilo(t)
+
+	_, err := f.provider().GetRecords(context.Background(), "example.org")
+	if !errors.Is(err, ErrDomainNotManaged) {
+		t.Fatalf("GetRecords for a foreign domain returned %v, want ErrDomainNotManaged", err)
+	}
+	if !strings.Contains(err.Error(), "example.org") {
+		t.Errorf("error %q doesn't name the domain", err)
+	}
+
+	_, err = f.provider().GetRecords(context.Background(), testZone)
+	if errors.Is(err, ErrDomainNotManaged) {
+		t.Errorf("GetRecords for a managed domain returned %v", err)
+	}
+}
+
+func TestErrRecordLimitReached(t *testing.T) {
+	tests := []struct {
+		detail string
+		limit  bool
+	}{
+		{"You have reached the maximum number of DNS records for this domain", true},
+		{"Record limit exceeded", true},
+		{"Invalid value", false},
+	}
+	for _, tt := range tests {
+		f := newFakeNamesilo(t)
+		f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+			writeReply(w, "dnsAddRecord", codeDNSModification, tt.detail, "")
+		})
+
+		_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}})
+		if err == nil {
+			t.Fatalf("append failing with %q succeeded", tt.detail)
+		}
+		if got := errors.Is(err, ErrRecordLimitReached); got != tt.limit {
+			t.Errorf("detail %q: errors.Is(err, ErrRecordLimitReached) = %v, want %v", tt.detail, got, tt.limit)
+		}
+	}
+}
+
+func TestBatchErrorIsAs(t *testing.T) {
+	batchErr := &BatchError{Errors: []error{
+		errors.New("first"),
+		&APIError{Operation: "dnsAddRecord", Code: codeDomainNotActive},
+	}}
+
+	// Call the methods directly as well, since errors.Is and errors.As
+	// follow Unwrap() []error themselves from Go 1.20.
+	if !batchErr.Is(ErrDomainNotManaged) || !errors.Is(batchErr, ErrDomainNotManaged) {
+		t.Error("BatchError doesn't match the ErrDomainNotManaged of one of its records")
+	}
+	if batchErr.Is(ErrRecordLimitReached) {
+		t.Error("BatchError matches ErrRecordLimitReached, which none of its records are")
+	}
+
+	var apiErr *APIError
+	if !batchErr.As(&apiErr) || apiErr.Code != codeDomainNotActive {
+		t.Errorf("BatchError.As found %v, want the record's *APIError", apiErr)
+	}
+	apiErr = nil
+	if !errors.As(fmt.Errorf("sync: %w", batchErr), &apiErr) || apiErr.Code != codeDomainNotActive {
+		t.Errorf("errors.As through a wrapped BatchError found %v, want the record's *APIError", apiErr)
+	}
+}
@@ -0,0 +1,46 @@
+package namesilo
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// responseFormat ties the type parameter sent to namesilo to the decoder
+// for the replies it produces, so that neither can be changed without the
+// other.
+type responseFormat struct {
+	// param is the value of the type request parameter.
+	param string
+
+	// name describes the format in error messages.
+	name string
+
+	// decode parses a reply from r into v.
+	decode func(r io.Reader, v interface{}) error
+
+	// accepts reports whether a response media type can hold the format.
+	accepts func(mediaType string) bool
+}
+
+// xmlFormat is namesilo's XML reply format, which the reply structs'
+// tags are written for.
+var xmlFormat = responseFormat{
+	param: "xml",
+	name:  "XML",
+	decode: func(r io.Reader, v interface{}) error {
+		decoder := xml.NewDecoder(r)
+		// Replies declaring a charset other than UTF-8, such as
+		// Windows-1252 from a Windows-based server, are converted.
+		decoder.CharsetReader = charset.NewReaderLabel
+		return decoder.Decode(v)
+	},
+	accepts: func(mediaType string) bool {
+		return strings.HasSuffix(mediaType, "xml") || mediaType == "text/plain"
+	},
+}
+
+// apiFormat is the format requests ask for and replies are decoded in.
+var apiFormat = xmlFormat
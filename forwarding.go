@@ -0,0 +1,86 @@
+package namesilo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URLForward describes a domain's URL forwarding configuration.
+type URLForward struct {
+	// Target is the URL the domain forwards to, empty if it isn't
+	// forwarded.
+	Target string
+
+	// Type is namesilo's description of the forward, such as
+	// "Permanent Forward (301)".
+	Type string
+}
+
+// SetURLForward forwards the zone's domain to target, which must be an
+// absolute http or https URL. forwardType is one of "301", "302" or
+// "cloaked".
+func (p *Provider) SetURLForward(ctx context.Context, zone, target string, forwardType string) error {
+	p.logf("SetURLForward %s %s %s", zone, target, forwardType)
+
+	switch forwardType {
+	case "301", "302", "cloaked":
+	default:
+		return fmt.Errorf("invalid forward type %q: must be 301, 302 or cloaked", forwardType)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid forward target %q: %w", target, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid forward target %q: must be an absolute http or https URL", target)
+	}
+
+	domain := getDomain(zone)
+
+	// namesilo takes the protocol and the rest of the URL separately.
+	// url.Parse lowercases the scheme, so cut it off the target by length
+	// rather than by matching it; a URL with a host always starts with
+	// the scheme and "://".
+	params := url.Values{
+		"domain":   {domain},
+		"protocol": {u.Scheme},
+		"address":  {target[len(u.Scheme+"://"):]},
+		"method":   {forwardType},
+	}
+
+	var reply apiReply
+	if err := p.call(ctx, "domainForward", params, &reply); err != nil {
+		return err
+	}
+
+	return reply.err(domain, "")
+}
+
+// GetURLForward returns the zone's domain forwarding configuration.
+func (p *Provider) GetURLForward(ctx context.Context, zone string) (URLForward, error) {
+	p.logf("GetURLForward %s", zone)
+
+	domain := getDomain(zone)
+
+	var reply struct {
+		apiReply
+		ForwardURL  string `xml:"reply>forward_url"`
+		ForwardType string `xml:"reply>forward_type"`
+	}
+
+	if err := p.call(ctx, "getDomainInfo", url.Values{"domain": {domain}}, &reply); err != nil {
+		return URLForward{}, err
+	}
+
+	if err := reply.err(domain, ""); err != nil {
+		return URLForward{}, err
+	}
+
+	return URLForward{
+		Target: strings.TrimSpace(reply.ForwardURL),
+		Type:   strings.TrimSpace(reply.ForwardType),
+	}, nil
+}
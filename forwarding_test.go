@@ -0,0 +1,83 @@
+package namesilo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestSetURLForward(t *testing.T) {
+	tests := []struct {
+		target   string
+		protocol string
+		address  string
+	}{
+		{"https://www.example.org/landing?src=a", "https", "www.example.org/landing?src=a"},
+		{"HTTPS://Example.org/Path", "https", "Example.org/Path"},
+		{"Http://www.example.org", "http", "www.example.org"},
+	}
+	for _, tt := range tests {
+		f := newFakeNamesilo(t)
+		f.handle("domainForward", func(w http.ResponseWriter, r *http.Request) {
+			writeReply(w, "domainForward", codeSuccess, "success", "")
+		})
+		p := f.provider()
+
+		if err := p.SetURLForward(context.Background(), testZone, tt.target, "302"); err != nil {
+			t.Fatal(err)
+		}
+		requests := f.received("domainForward")
+		if len(requests) != 1 {
+			t.Fatalf("sent %d domainForward requests, want 1", len(requests))
+		}
+		params := requests[0].Params
+		want := map[string]string{
+			"domain":   testZone,
+			"protocol": tt.protocol,
+			"address":  tt.address,
+			"method":   "302",
+		}
+		for name, value := range want {
+			if got := params.Get(name); got != value {
+				t.Errorf("%s: %s = %q, want %q", tt.target, name, got, value)
+			}
+		}
+	}
+}
+
+func TestSetURLForwardInvalid(t *testing.T) {
+	tests := []struct {
+		target, forwardType string
+	}{
+		{"https://www.example.org", "307"},
+		{"https://www.example.org", ""},
+		{"ftp://files.example.org", "301"},
+		{"www.example.org", "301"},
+	}
+	for _, tt := range tests {
+		f := newFakeNamesilo(t)
+		if err := f.provider().SetURLForward(context.Background(), testZone, tt.target, tt.forwardType); err == nil {
+			t.Errorf("SetURLForward(%q, %q) succeeded", tt.target, tt.forwardType)
+		}
+		if n := f.count(""); n != 0 {
+			t.Errorf("SetURLForward(%q, %q) made %d requests", tt.target, tt.forwardType, n)
+		}
+	}
+}
+
+func TestGetURLForward(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("getDomainInfo", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "getDomainInfo", codeSuccess, "success",
+			"<forward_url>https://www.example.org</forward_url><forward_type>Permanent Forward (301)</forward_type>")
+	})
+
+	forward, err := f.provider().GetURLForward(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := URLForward{Target: "https://www.example.org", Type: "Permanent Forward (301)"}
+	if forward != want {
+		t.Errorf("GetURLForward = %+v, want %+v", forward, want)
+	}
+}
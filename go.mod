@@ -2,4 +2,10 @@ module github.com/crakkhead/libdns-namesilo
 
 go 1.18
 
-require github.com/libdns/libdns v0.2.1
+require (
+	github.com/libdns/libdns v0.2.1
+	golang.org/x/net v0.10.0
+	golang.org/x/time v0.3.0
+)
+
+require golang.org/x/text v0.9.0 // indirect
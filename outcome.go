@@ -0,0 +1,128 @@
+package namesilo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libdns/libdns"
+)
+
+// RecordStatus is the outcome of one record in a batch operation.
+type RecordStatus int
+
+const (
+	// RecordSucceeded means the record was created, updated or deleted.
+	RecordSucceeded RecordStatus = iota
+	// RecordSkipped means no request was made for the record, because
+	// there was nothing to change or the batch stopped early.
+	RecordSkipped
+	// RecordFailed means the record could not be processed.
+	RecordFailed
+)
+
+func (s RecordStatus) String() string {
+	switch s {
+	case RecordSucceeded:
+		return "succeeded"
+	case RecordSkipped:
+		return "skipped"
+	case RecordFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// RecordOutcome describes what a batch operation did with one record.
+type RecordOutcome struct {
+	Record libdns.Record
+	Status RecordStatus
+
+	// Reason says why a record was skipped.
+	Reason string
+
+	// Err is the error a failed record ran into.
+	Err error
+}
+
+// BatchResult lists the outcome of each record of the batch operations
+// made with a context from TrackOutcomes, in the order they were settled.
+type BatchResult struct {
+	Outcomes []RecordOutcome
+}
+
+// Failed returns the outcomes of the records that failed.
+func (r BatchResult) Failed() []RecordOutcome {
+	var failed []RecordOutcome
+	for _, outcome := range r.Outcomes {
+		if outcome.Status == RecordFailed {
+			failed = append(failed, outcome)
+		}
+	}
+	return failed
+}
+
+// outcomesKey is the context key for the *outcomeTracker installed by
+// TrackOutcomes.
+type outcomesKey struct{}
+
+type outcomeTracker struct {
+	mu       sync.Mutex
+	outcomes []RecordOutcome
+}
+
+// TrackOutcomes returns a context that records the outcome of every record
+// handled by batch methods such as AppendRecords, SetRecords and
+// DeleteRecords called with it, and a function returning them so far.
+// Without it, batch methods only return the records that succeeded and
+// the errors of those that didn't.
+func TrackOutcomes(ctx context.Context) (context.Context, func() BatchResult) {
+	tracker := &outcomeTracker{}
+	return context.WithValue(ctx, outcomesKey{}, tracker), func() BatchResult {
+		tracker.mu.Lock()
+		defer tracker.mu.Unlock()
+		return BatchResult{Outcomes: append([]RecordOutcome(nil), tracker.outcomes...)}
+	}
+}
+
+func reportOutcome(ctx context.Context, outcome RecordOutcome) {
+	tracker, _ := ctx.Value(outcomesKey{}).(*outcomeTracker)
+	if tracker == nil {
+		return
+	}
+	tracker.mu.Lock()
+	tracker.outcomes = append(tracker.outcomes, outcome)
+	tracker.mu.Unlock()
+}
+
+// reportSucceeded, reportSkipped and reportFailed record a record's
+// outcome if the context tracks outcomes.
+func reportSucceeded(ctx context.Context, record libdns.Record) {
+	reportOutcome(ctx, RecordOutcome{Record: record, Status: RecordSucceeded})
+}
+
+func reportSkipped(ctx context.Context, reason string, records ...libdns.Record) {
+	for _, record := range records {
+		reportOutcome(ctx, RecordOutcome{Record: record, Status: RecordSkipped, Reason: reason})
+	}
+}
+
+func reportFailed(ctx context.Context, record libdns.Record, err error) {
+	reportOutcome(ctx, RecordOutcome{Record: record, Status: RecordFailed, Err: err})
+}
+
+// reportAllFailed records err as the outcome of every record of a batch
+// that failed before handling any of them, as when the zone can't be
+// fetched.
+func reportAllFailed(ctx context.Context, err error, records ...libdns.Record) {
+	for _, record := range records {
+		reportFailed(ctx, record, err)
+	}
+}
+
+// skippedAfterFailure is the reason given for records a batch didn't get
+// to after stopping at a failure.
+const skippedAfterFailure = "batch stopped after an earlier failure"
+
+// skippedInvalidBatch is the reason given for the valid records of a batch
+// refused as a whole because another of its records is invalid.
+const skippedInvalidBatch = "batch refused because of an invalid record"
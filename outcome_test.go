@@ -0,0 +1,139 @@
+package namesilo
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/libdns/libdns"
+)
+
+func TestTrackOutcomes(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		if r.Form.Get("rrhost") == "b" {
+			writeReply(w, "dnsAddRecord", codeDNSModification, "Invalid value", "")
+			return
+		}
+		f.serveDefault(w, r)
+	})
+	p := f.provider()
+
+	ctx, outcomes := TrackOutcomes(context.Background())
+	_, err := p.AppendRecords(ctx, testZone, []libdns.Record{
+		{Type: "TXT", Name: "a", Value: "x"},
+		{Type: "TXT", Name: "b", Value: "x"},
+		{Type: "TXT", Name: "c", Value: "x"},
+	})
+	if err == nil {
+		t.Fatal("AppendRecords succeeded")
+	}
+	if _, err := p.SetRecords(ctx, testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name   string
+		status RecordStatus
+		reason string
+	}{
+		{"a", RecordSucceeded, ""},
+		{"b", RecordFailed, ""},
+		{"c", RecordSkipped, skippedAfterFailure},
+		{"www", RecordSkipped, "unchanged"},
+	}
+	result := outcomes()
+	if len(result.Outcomes) != len(want) {
+		t.Fatalf("tracked %d outcomes, want %d: %+v", len(result.Outcomes), len(want), result.Outcomes)
+	}
+	for i, w := range want {
+		got := result.Outcomes[i]
+		if got.Record.Name != w.name || got.Status != w.status || got.Reason != w.reason {
+			t.Errorf("outcome %d is %s %v %q, want %s %v %q", i, got.Record.Name, got.Status, got.Reason, w.name, w.status, w.reason)
+		}
+	}
+	if failed := result.Failed(); len(failed) != 1 || failed[0].Err == nil {
+		t.Errorf("Failed() = %+v, want record b with its error", failed)
+	}
+
+	// Without TrackOutcomes nothing is tracked, and nothing breaks.
+	if _, err := p.SetRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(outcomes().Outcomes); n != len(want) {
+		t.Errorf("tracked %d outcomes after a call with another context", n)
+	}
+}
+
+func TestTrackOutcomesBeforeTheBatch(t *testing.T) {
+	t.Run("system records", func(t *testing.T) {
+		f := newFakeNamesilo(t, nsRecord("1", "NS", "", "ns1.dnsowl.com"))
+		p := f.provider()
+		p.ExcludeSystemRecords = true
+
+		ctx, outcomes := TrackOutcomes(context.Background())
+		deleted, err := p.DeleteRecords(ctx, testZone, []libdns.Record{{ID: "1"}})
+		if err != nil || len(deleted) != 0 {
+			t.Fatalf("DeleteRecords = %v, %v; want nothing deleted", deleted, err)
+		}
+		if _, err := p.SetRecords(ctx, testZone, []libdns.Record{{Type: "NS", Name: "@", Value: "ns2.dnsowl.com"}}); err != nil {
+			t.Fatal(err)
+		}
+		result := outcomes()
+		if len(result.Outcomes) != 2 {
+			t.Fatalf("tracked %+v, want an outcome per record", result.Outcomes)
+		}
+		for _, outcome := range result.Outcomes {
+			if outcome.Status != RecordSkipped || outcome.Reason != "system record" {
+				t.Errorf("outcome %+v, want skipped as a system record", outcome)
+			}
+		}
+	})
+
+	t.Run("zone fetch fails", func(t *testing.T) {
+		f := newFakeNamesilo(t)
+		f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+			writeReply(w, "dnsListRecords", codeInvalidAPIKey, "Invalid API Key", "")
+		})
+		records := []libdns.Record{
+			{Type: "TXT", Name: "a", Value: "x"},
+			{Type: "TXT", Name: "b", Value: "x"},
+		}
+
+		ctx, outcomes := TrackOutcomes(context.Background())
+		p := f.provider()
+		if _, err := p.SetRecords(ctx, testZone, records); err == nil {
+			t.Error("SetRecords succeeded")
+		}
+		if _, err := p.DeleteRecords(ctx, testZone, records); err == nil {
+			t.Error("DeleteRecords succeeded")
+		}
+		result := outcomes()
+		if len(result.Outcomes) != 4 || len(result.Failed()) != 4 {
+			t.Errorf("tracked %+v, want every record failed", result.Outcomes)
+		}
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		f := newFakeNamesilo(t)
+		ctx, outcomes := TrackOutcomes(context.Background())
+		_, err := f.provider().DeleteRecords(ctx, testZone, []libdns.Record{
+			{Type: "TXT", Name: "a", Value: "x"},
+			{Type: "TXT", Name: "a.other.com.", Value: "x"},
+			{Type: "TXT", Name: "c", Value: "x"},
+		})
+		if err == nil {
+			t.Fatal("DeleteRecords accepted a name outside the zone")
+		}
+		want := []RecordStatus{RecordSkipped, RecordFailed, RecordSkipped}
+		result := outcomes()
+		if len(result.Outcomes) != len(want) {
+			t.Fatalf("tracked %+v, want an outcome per record", result.Outcomes)
+		}
+		for i, status := range want {
+			if result.Outcomes[i].Status != status {
+				t.Errorf("outcome %d is %v, want %v", i, result.Outcomes[i].Status, status)
+			}
+		}
+	})
+}
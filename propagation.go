@@ -0,0 +1,212 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// DefaultNameservers are namesilo's authoritative nameservers, queried by
+// CheckPropagation for domains that report no nameservers of their own.
+var DefaultNameservers = []string{"ns1.dnsowl.com", "ns2.dnsowl.com", "ns3.dnsowl.com"}
+
+// Resolver looks up DNS records directly on a given nameserver.
+type Resolver interface {
+	LookupTXT(ctx context.Context, nameserver, name string) ([]string, error)
+}
+
+// nameserverResolver is the default Resolver, sending queries straight to
+// the nameserver instead of going through the system's recursive resolver.
+type nameserverResolver struct{}
+
+func (nameserverResolver) LookupTXT(ctx context.Context, nameserver, name string) ([]string, error) {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort(nameserver, "53"))
+		},
+	}
+	return r.LookupTXT(ctx, name)
+}
+
+func (p *Provider) resolver() Resolver {
+	if p.Resolver != nil {
+		return p.Resolver
+	}
+	return nameserverResolver{}
+}
+
+// getFQDN returns the fully qualified name, without trailing dot, of a
+// record name that may be relative to the zone or absolute.
+func getFQDN(zone, name string) string {
+	domain := getDomain(zone)
+	host := getHostname(domain, name)
+	if host == "" {
+		return domain
+	}
+	return host + "." + domain
+}
+
+// GetNameservers returns the nameservers the domain is delegated to, as
+// registered with namesilo.
+func (p *Provider) GetNameservers(ctx context.Context, zone string) ([]string, error) {
+	domain := getDomain(zone)
+	p.logf("GetNameservers %s", domain)
+
+	var reply struct {
+		apiReply
+		Nameservers []string `xml:"reply>nameservers>nameserver"`
+	}
+
+	if err := p.call(ctx, "getDomainInfo", url.Values{"domain": {domain}}, &reply); err != nil {
+		return nil, err
+	}
+
+	if err := reply.err(domain, ""); err != nil {
+		return nil, err
+	}
+
+	var nameservers []string
+	for _, ns := range reply.Nameservers {
+		if ns = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(ns), ".")); ns != "" {
+			nameservers = append(nameservers, ns)
+		}
+	}
+	return nameservers, nil
+}
+
+// authoritativeNameservers returns the nameservers to check propagation
+// on: the domain's own, or DefaultNameservers if it reports none.
+func (p *Provider) authoritativeNameservers(ctx context.Context, zone string) ([]string, error) {
+	nameservers, err := p.GetNameservers(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	if len(nameservers) == 0 {
+		return DefaultNameservers, nil
+	}
+	return nameservers, nil
+}
+
+// CheckPropagation reports whether the TXT record name in zone resolves to
+// value on every one of the domain's authoritative nameservers, as found
+// with GetNameservers.
+func (p *Provider) CheckPropagation(ctx context.Context, zone, name, value string) (bool, error) {
+	nameservers, err := p.authoritativeNameservers(ctx, zone)
+	if err != nil {
+		return false, err
+	}
+	return p.checkPropagation(ctx, nameservers, getFQDN(zone, name), value)
+}
+
+func (p *Provider) checkPropagation(ctx context.Context, nameservers []string, fqdn, value string) (bool, error) {
+	resolver := p.resolver()
+
+	for _, nameserver := range nameservers {
+		values, err := resolver.LookupTXT(ctx, nameserver, fqdn)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+		if !containsValue(values, value) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// WaitForPropagation polls the domain's authoritative nameservers, with
+// backoff, until record is visible on all of them, and fails if that
+// doesn't happen within timeout. Lookups that time out or fail with
+// SERVFAIL count as the record not being visible yet. Only TXT records,
+// such as ACME challenge records, can be checked.
+func (p *Provider) WaitForPropagation(ctx context.Context, zone string, record libdns.Record, timeout time.Duration) error {
+	if !strings.EqualFold(record.Type, "TXT") {
+		return fmt.Errorf("cannot check propagation of %s records, only TXT", record.Type)
+	}
+
+	// The context bounds lookups that hang; the polling itself ends on
+	// the deadline below.
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	nameservers, err := p.authoritativeNameservers(ctx, zone)
+	if err != nil {
+		return err
+	}
+	fqdn := getFQDN(zone, record.Name)
+
+	// The deadline is kept on the provider's clock, like pollRecords
+	// does, so that the waits between lookups and the timeout agree.
+	clock := p.clock()
+	deadline := clock.Now().Add(timeout)
+	var lookupErr error
+	notVisible := func() error {
+		if lookupErr != nil {
+			return fmt.Errorf("TXT record %s not visible on all nameservers after %v (last lookup error: %v): %w", fqdn, timeout, lookupErr, context.DeadlineExceeded)
+		}
+		return fmt.Errorf("TXT record %s not visible on all nameservers after %v: %w", fqdn, timeout, context.DeadlineExceeded)
+	}
+	for attempt := 0; ; attempt++ {
+		ok, err := p.checkPropagation(ctx, nameservers, fqdn, record.Value)
+		if err != nil && ctx.Err() == nil {
+			// A nameserver timing out or failing with SERVFAIL may
+			// answer the next poll, so the record only counts as not
+			// yet visible.
+			if !isTemporaryDNSError(err) {
+				return err
+			}
+			p.debugf("WaitForPropagation: %v; polling again", err)
+			lookupErr = err
+		}
+		if ok {
+			return nil
+		}
+
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			return notVisible()
+		}
+		delay := backoff(attempt, time.Second, 30*time.Second)
+		if delay > remaining {
+			delay = remaining
+		}
+		if err := p.wait(ctx, delay); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return notVisible()
+			}
+			return err
+		}
+	}
+}
+
+// isTemporaryDNSError reports whether a failed lookup may succeed if
+// tried again, as after a timeout or a SERVFAIL reply.
+func isTemporaryDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
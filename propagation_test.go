@@ -0,0 +1,216 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// stubResolver answers TXT lookups from a table of values by nameserver
+// and name, recording the lookups made.
+type stubResolver struct {
+	mu      sync.Mutex
+	values  map[string]map[string][]string
+	queries []string
+
+	// errs are returned, in order, by the first lookups.
+	errs []error
+}
+
+func (r *stubResolver) set(nameserver, name string, values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = make(map[string]map[string][]string)
+	}
+	if r.values[nameserver] == nil {
+		r.values[nameserver] = make(map[string][]string)
+	}
+	r.values[nameserver][name] = values
+}
+
+func (r *stubResolver) LookupTXT(ctx context.Context, nameserver, name string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, nameserver+" "+name)
+
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return nil, err
+	}
+
+	values, ok := r.values[nameserver][name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: nameserver, IsNotFound: true}
+	}
+	return values, nil
+}
+
+// serveNameservers makes the fake answer getDomainInfo with the given
+// nameservers.
+func (f *fakeNamesilo) serveNameservers(nameservers ...string) {
+	f.handle("getDomainInfo", func(w http.ResponseWriter, r *http.Request) {
+		var body strings.Builder
+		body.WriteString("<nameservers>")
+		for _, ns := range nameservers {
+			body.WriteString("<nameserver>" + escapeXML(ns) + "</nameserver>")
+		}
+		body.WriteString("</nameservers>")
+		writeReply(w, "getDomainInfo", codeSuccess, "success", body.String())
+	})
+}
+
+func TestCheckPropagation(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.serveNameservers("ns1.dnsowl.com", "ns2.dnsowl.com")
+
+	const fqdn = "_acme-challenge.example.com"
+	tests := []struct {
+		name    string
+		ns1     []string
+		ns2     []string
+		visible bool
+	}{
+		{"on every nameserver", []string{"token"}, []string{"other", "token"}, true},
+		{"on one nameserver", []string{"token"}, []string{"other"}, false},
+		{"nowhere", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &stubResolver{}
+			if tt.ns1 != nil {
+				resolver.set("ns1.dnsowl.com", fqdn, tt.ns1...)
+			}
+			if tt.ns2 != nil {
+				resolver.set("ns2.dnsowl.com", fqdn, tt.ns2...)
+			}
+			p := f.provider()
+			p.Resolver = resolver
+
+			visible, err := p.CheckPropagation(context.Background(), testZone, "_acme-challenge", "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if visible != tt.visible {
+				t.Errorf("CheckPropagation = %v, want %v", visible, tt.visible)
+			}
+		})
+	}
+}
+
+func TestWaitForPropagation(t *testing.T) {
+	const fqdn = "_acme-challenge.example.com"
+	timeout := &net.DNSError{Err: "i/o timeout", Name: fqdn, Server: "ns1.dnsowl.com", IsTimeout: true}
+	servfail := &net.DNSError{Err: "server misbehaving", Name: fqdn, Server: "ns1.dnsowl.com", IsTemporary: true}
+	notFound := &net.DNSError{Err: "no such host", Name: fqdn, Server: "ns1.dnsowl.com", IsNotFound: true}
+	refused := errors.New("connection refused")
+
+	tests := []struct {
+		name    string
+		errs    []error
+		ok      bool
+		lookups int
+	}{
+		{"visible at once", nil, true, 1},
+		{"after temporary failures", []error{timeout, servfail, notFound}, true, 4},
+		{"after a permanent failure", []error{notFound, refused}, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.serveNameservers("ns1.dnsowl.com")
+			resolver := &stubResolver{errs: tt.errs}
+			resolver.set("ns1.dnsowl.com", fqdn, "token")
+			p := f.provider()
+			p.Resolver = resolver
+			clock := &instantClock{}
+			p.Clock = clock
+
+			err := p.WaitForPropagation(context.Background(), testZone, libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token"}, time.Minute)
+			if (err == nil) != tt.ok {
+				t.Errorf("WaitForPropagation returned %v, want success %v", err, tt.ok)
+			}
+			if len(resolver.queries) != tt.lookups {
+				t.Errorf("made %d lookups, want %d", len(resolver.queries), tt.lookups)
+			}
+			if waits := len(clock.waited()); waits != tt.lookups-1 {
+				t.Errorf("waited %d times between %d lookups", waits, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestWaitForPropagationTimeout(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.serveNameservers("ns1.dnsowl.com")
+	resolver := &stubResolver{}
+	resolver.set("ns1.dnsowl.com", "_acme-challenge.example.com", "stale")
+	p := f.provider()
+	p.Resolver = resolver
+	clock := &instantClock{}
+	p.Clock = clock
+
+	err := p.WaitForPropagation(context.Background(), testZone, libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token"}, 2*time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitForPropagation returned %v, want a deadline error", err)
+	}
+
+	var total time.Duration
+	waits := clock.waited()
+	for _, d := range waits {
+		total += d
+	}
+	if total != 2*time.Minute {
+		t.Errorf("waited %v in all, want the 2m timeout", total)
+	}
+	if len(resolver.queries) != len(waits)+1 {
+		t.Errorf("made %d lookups around %d waits", len(resolver.queries), len(waits))
+	}
+}
+
+func TestPropagationNameservers(t *testing.T) {
+	const fqdn = "_acme-challenge.example.com"
+	tests := []struct {
+		name        string
+		registered  []string
+		nameservers []string
+	}{
+		{"registered", []string{" NS1.Example.net. ", "ns2.example.net"}, []string{"ns1.example.net", "ns2.example.net"}},
+		{"none registered", nil, DefaultNameservers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.serveNameservers(tt.registered...)
+			resolver := &stubResolver{}
+			for _, ns := range tt.nameservers {
+				resolver.set(ns, fqdn, "token")
+			}
+			p := f.provider()
+			p.Resolver = resolver
+
+			visible, err := p.CheckPropagation(context.Background(), testZone, "_acme-challenge", "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !visible {
+				t.Error("CheckPropagation = false, want true")
+			}
+			var want []string
+			for _, ns := range tt.nameservers {
+				want = append(want, ns+" "+fqdn)
+			}
+			if strings.Join(resolver.queries, ", ") != strings.Join(want, ", ") {
+				t.Errorf("queried %v, want %v", resolver.queries, want)
+			}
+		})
+	}
+}
@@ -4,332 +4,1282 @@ package namesilo
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/libdns/libdns"
+	"golang.org/x/time/rate"
 )
 
 // Provider facilitates DNS record manipulation with namesilo.
 type Provider struct {
 	APIToken string
+
+	// PropagationPollTimeout bounds how long SetRecords and DeleteRecords
+	// keep re-fetching the zone when a record that was just appended is
+	// not visible yet. Zero disables polling.
+	PropagationPollTimeout time.Duration
+
+	// Logger receives the provider's log output. If nil, the standard
+	// library's default logger is used.
+	Logger Logger
+
+	// Debug enables verbose logging, such as how SetRecords classified
+	// each record as an update or an append.
+	Debug bool
+
+	// ExcludeSystemRecords hides the zone's SOA and apex NS records from
+	// GetRecords and prevents SetRecords and DeleteRecords from touching
+	// them.
+	ExcludeSystemRecords bool
+
+	// TTLRounding controls how TTLs that aren't in AllowedTTLs are
+	// adjusted before being sent. The default is RoundUp.
+	TTLRounding TTLRounding
+
+	// BatchTimeout bounds the total time of a batch operation such as
+	// SetRecords, across all of its requests and retries. Zero means no
+	// limit beyond the caller's context. Batch methods return the records
+	// processed so far along with the error when it fires.
+	BatchTimeout time.Duration
+
+	// IgnoreMissing makes DeleteRecordByID succeed when the record is
+	// already gone, for idempotent cleanup.
+	IgnoreMissing bool
+
+	// Resolver is used by CheckPropagation to query the authoritative
+	// nameservers. If nil, queries are sent directly over the network.
+	Resolver Resolver
+
+	// DialNetwork restricts API connections to one address family: "tcp4"
+	// for IPv4 only or "tcp6" for IPv6 only. The default is dual-stack.
+	DialNetwork string
+
+	// UsePOST sends the API key and operation parameters, including
+	// record values, as a form-encoded POST body instead of in the URL,
+	// keeping them out of proxy and server logs and clear of URL length
+	// limits.
+	UsePOST bool
+
+	// MaxURLLength is the longest request URL sent before failing with a
+	// descriptive error rather than letting namesilo reject it. It applies
+	// unless UsePOST is set. Defaults to 8 KiB.
+	MaxURLLength int
+
+	// MaxRetries is how many times a request failing with a transient
+	// error, judged by namesilo's reply code or else the HTTP status, is
+	// retried, with a randomized exponential backoff capped at 30 seconds
+	// between attempts. Zero disables retries.
+	MaxRetries int
+
+	// AppendStaleIDs makes SetRecords treat a record whose ID is no longer
+	// in the zone like a record without ID, updating a record of the same
+	// type and name or else appending it. By default such a record fails
+	// with ErrRecordNotFound before any change is made, unless
+	// PropagationPollTimeout is set, in which case SetRecords waits for the
+	// ID to appear as it may belong to a record that was just appended.
+	AppendStaleIDs bool
+
+	// TraceHeader is the HTTP request header carrying the trace ID set
+	// with WithTraceID. Defaults to X-Request-ID.
+	TraceHeader string
+
+	// ContinueOnError makes batch methods such as AppendRecords, SetRecords
+	// and DeleteRecords carry on past a failing record, returning every
+	// record that succeeded along with the failures. Several failures are
+	// returned as a *BatchError. By default a batch stops at the first
+	// failure.
+	ContinueOnError bool
+
+	// HourlyRequestBudget caps the API requests made per hour, across all
+	// operations, to stay clear of namesilo's rate limits. Requests are
+	// spaced out evenly, so a large batch is paced rather than failing
+	// midway; the wait ends early if the context is done. Zero means no
+	// limit.
+	HourlyRequestBudget int
+
+	// ReturnServerState makes AppendRecords re-fetch the zone after adding
+	// records and return them as namesilo stored them, with any
+	// normalization of name, TTL or value applied, instead of as given.
+	// This costs one extra request per batch.
+	ReturnServerState bool
+
+	// ClientTrace, if set, is called for every API request and the trace it
+	// returns is attached to the request, for timing its DNS lookup,
+	// connection, TLS handshake and first response byte. It composes with
+	// any trace already in the caller's context.
+	ClientTrace func(operation string) *httptrace.ClientTrace
+
+	// TLSConfig, if set, replaces the TLS configuration of API connections.
+	// It is meant for tests against a local server, for instance one from
+	// httptest.NewTLSServer, trusted by setting RootCAs or, for tests
+	// only, InsecureSkipVerify. The default verifies namesilo's
+	// certificate as usual.
+	TLSConfig *tls.Config
+
+	// MatchStrategy controls how SetRecords decides whether a record
+	// without ID updates an existing record or is appended. The default
+	// is MatchTypeName.
+	MatchStrategy MatchStrategy
+
+	// RateLimiter, if set, paces API requests in place of
+	// HourlyRequestBudget. It is opt-in, and meant to be shared by several
+	// Providers, possibly in different services' code paths, that use the
+	// same namesilo account, so that together they stay within its limits.
+	RateLimiter *rate.Limiter
+
+	// RetryDetails are substrings, matched case-insensitively, of reply
+	// detail messages that mark a failed reply as transient, so that it is
+	// retried as set by MaxRetries whatever its reply code. If nil, a
+	// built-in list matching messages such as "please try again later" is
+	// used; set it to an empty slice to retry on reply codes alone.
+	RetryDetails []string
+
+	// Clock is the time source for waits between retries and polls. If
+	// nil, the system clock is used; tests can set a fake one to step
+	// through backoff schedules without sleeping.
+	Clock Clock
+
+	// SkipExisting makes AppendRecords leave out records whose type, name
+	// and value are already in the zone, instead of adding a second copy.
+	// The check and the creation are serialized per zone within the
+	// process, so concurrent appends of the same record, even through
+	// different Providers, create it only once.
+	SkipExisting bool
+
+	client  *http.Client
+	limiter *rate.Limiter
+
+	// apiHost replaces the API endpoint when set, as tests do.
+	apiHost string
+}
+
+// Logger is the interface used for log output. It is satisfied by
+// *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+func (p *Provider) logf(format string, v ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
 }
 
+func (p *Provider) debugf(format string, v ...interface{}) {
+	if p.Debug {
+		p.logf(format, v...)
+	}
+}
+
+// logRecords returns copies of records with their values shortened as in
+// errors, for logging a batch without writing out ACME tokens or DKIM keys.
+func logRecords(records []libdns.Record) []libdns.Record {
+	shortened := make([]libdns.Record, len(records))
+	for i, record := range records {
+		record.Value = shortenValue(record.Value)
+		shortened[i] = record
+	}
+	return shortened
+}
+
+// getDomain returns the zone lowercased and without trailing dot, the form
+// namesilo uses for domains. Record methods normalize their zone argument
+// with it on entry so that the lookup and modification paths agree.
 func getDomain(zone string) string {
-	return strings.TrimSuffix(zone, ".")
+	return strings.ToLower(strings.TrimSuffix(zone, "."))
 }
 
-func getHostname(domain, name string) string {
-	return strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "."), domain), ".")
+// getHostname returns the rrhost namesilo expects for a record name, which
+// may be relative to the zone ("www") or absolute ("www.example.com", with
+// or without trailing dot). The apex, given as "", "@" or the zone itself,
+// is returned as the empty string. DNS names are case-insensitive, so the
+// result is lowercased for matching and sending alike.
+func getHostname(zone, name string) string {
+	domain := getDomain(zone)
+	name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+
+	if name == "@" || name == domain {
+		return ""
+	}
+	return strings.TrimSuffix(name, "."+domain)
 }
 
 func (p *Provider) getApiHost() string {
+	if p.apiHost != "" {
+		return p.apiHost
+	}
 	return "https://www.namesilo.com/api"
 }
 
-// GetRecords lists all the records in the zone.
+// NamesiloRecord is a record exactly as dnsListRecords returns it.
+type NamesiloRecord struct {
+	RecordID string `xml:"record_id"`
+	Type     string `xml:"type"`
+
+	// Host is the record's fully qualified name as namesilo formats it.
+	Host  string `xml:"host"`
+	Value string `xml:"value"`
+
+	// TTL is in seconds.
+	TTL int `xml:"ttl"`
+
+	// Distance is the priority of MX and SRV records.
+	Distance int `xml:"distance"`
+
+	// badTTL holds the ttl text if it isn't a number, leaving TTL zero.
+	badTTL string
+
+	// badDistance likewise holds the distance text if it isn't a number,
+	// leaving Distance zero.
+	badDistance string
+}
+
+// UnmarshalXML decodes a resource_record element, tolerating whitespace
+// around numbers and a TTL or distance that isn't a number at all.
+func (r *NamesiloRecord) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		RecordID string `xml:"record_id"`
+		Type     string `xml:"type"`
+		Host     string `xml:"host"`
+		Value    string `xml:"value"`
+		TTL      string `xml:"ttl"`
+		Distance string `xml:"distance"`
+	}
+	if err := d.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+
+	*r = NamesiloRecord{
+		RecordID: raw.RecordID,
+		Type:     raw.Type,
+		Host:     raw.Host,
+		Value:    raw.Value,
+	}
+	if ttl := strings.TrimSpace(raw.TTL); ttl != "" {
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			r.badTTL = raw.TTL
+		}
+		r.TTL = n
+	}
+	if distance := strings.TrimSpace(raw.Distance); distance != "" {
+		n, err := strconv.Atoi(distance)
+		if err != nil {
+			r.badDistance = raw.Distance
+			n = 0
+		}
+		r.Distance = n
+	}
+	return nil
+}
+
+// GetRawRecords lists the records in the zone with namesilo's own fields,
+// unfiltered and in the order namesilo returns them. Most callers want
+// GetRecords instead.
+func (p *Provider) GetRawRecords(ctx context.Context, zone string) ([]NamesiloRecord, error) {
+	zone = getDomain(zone)
+	p.logf("GetRawRecords %s", zone)
+
+	return p.listRecords(ctx, zone)
+}
+
+// listRecords fetches the zone's records with dnsListRecords.
+func (p *Provider) listRecords(ctx context.Context, domain string) ([]NamesiloRecord, error) {
+	// The reply doesn't state how many records the zone holds, so the
+	// parsed records can't be checked against a count. A reply cut short
+	// fails to decode instead, as its elements are left unclosed.
+	var reply struct {
+		apiReply
+		Records []NamesiloRecord `xml:"reply>resource_record"`
+	}
+
+	if err := p.call(ctx, "dnsListRecords", url.Values{"domain": {domain}}, &reply); err != nil {
+		return nil, err
+	}
+	// A failed listing carries no records, which callers like
+	// DeleteRecords would otherwise take for an empty zone.
+	if err := reply.err(domain, ""); err != nil {
+		return nil, err
+	}
+
+	return reply.Records, nil
+}
+
+// GetRecords lists all the records in the zone. Records namesilo lists
+// without an ID can't be managed through the API and are left out. The
+// records are sorted by name, type and value, as namesilo's own order can
+// differ from one call to the next.
 func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
-	log.Println("GetRecords", zone)
+	zone = getDomain(zone)
+	p.logf("GetRecords %s", zone)
 
-	client := http.Client{}
+	return p.getRecords(ctx, zone, p.ExcludeSystemRecords)
+}
 
-	domain := getDomain(zone)
+// zoneRecords fetches the zone for the methods that change it. Unlike
+// GetRecords it keeps the system records even when ExcludeSystemRecords is
+// set, so that a record given only by its ID can be recognized as one.
+func (p *Provider) zoneRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	return p.getRecords(ctx, zone, false)
+}
 
-	req, err := http.NewRequest("GET", p.getApiHost()+"/dnsListRecords?version=1&type=xml&key="+p.APIToken+"&domain="+domain, nil)
+// getRecords fetches and converts the zone's records, leaving out the
+// system records if excludeSystem is set.
+func (p *Provider) getRecords(ctx context.Context, zone string, excludeSystem bool) ([]libdns.Record, error) {
+	raw, err := p.listRecords(ctx, zone)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := client.Do(req)
+	var records []libdns.Record
+
+	for _, record := range raw {
+		rec, ok := p.toLibdnsRecord(zone, record)
+		if !ok || excludeSystem && isSystemRecord(zone, rec) {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	sortRecords(records)
+	return records, nil
+}
+
+// toLibdnsRecord converts a record as namesilo lists it, reporting false
+// for records that can't be managed through the API.
+func (p *Provider) toLibdnsRecord(zone string, record NamesiloRecord) (libdns.Record, bool) {
+	if record.RecordID == "" {
+		// Without an ID the record can't be updated or deleted, so
+		// reconciliation must not see it.
+		p.debugf("GetRecords: skipping %s record %s without record_id", record.Type, record.Host)
+		return libdns.Record{}, false
+	}
+	rec := libdns.Record{
+		ID:       record.RecordID,
+		Type:     record.Type,
+		Name:     record.Host,
+		Value:    record.Value,
+		TTL:      TTLFromSeconds(record.TTL),
+		Priority: record.Distance,
+	}
+	if record.badTTL != "" {
+		p.debugf("GetRecords: %s record %s has invalid TTL %q, assuming %v", record.Type, record.Host, record.badTTL, DefaultTTL)
+		rec.TTL = DefaultTTL
+	}
+	if record.badDistance != "" {
+		p.debugf("GetRecords: %s record %s has invalid distance %q, assuming 0", record.Type, record.Host, record.badDistance)
+	}
+	if isTXT(rec.Type) {
+		rec.Value = unquoteTXT(rec.Value)
+	}
+	return rec, true
+}
+
+// sortRecords orders records by name, type and value, keeping the order
+// of records that are equal in all three.
+func sortRecords(records []libdns.Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+}
+
+// GetRecordsFiltered lists the records in the zone of the given type.
+// namesilo's dnsListRecords has no type filter, so the whole zone is
+// fetched and filtered locally.
+func (p *Provider) GetRecordsFiltered(ctx context.Context, zone, recordType string) ([]libdns.Record, error) {
+	records, err := p.GetRecords(ctx, zone)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("could not get records: Domain: %s; Status: %v; Body: %s", domain, resp.StatusCode, string(bodyBytes))
+	var filtered []libdns.Record
+	for _, record := range records {
+		if strings.EqualFold(record.Type, recordType) {
+			filtered = append(filtered, record)
+		}
 	}
 
-	result, err := ioutil.ReadAll(resp.Body)
+	return filtered, nil
+}
+
+// GetRecordsByType fetches the zone once and returns its records grouped
+// by record type.
+func (p *Provider) GetRecordsByType(ctx context.Context, zone string) (map[string][]libdns.Record, error) {
+	records, err := p.GetRecords(ctx, zone)
 	if err != nil {
 		return nil, err
 	}
 
-	var resultObj struct {
-		Records []struct {
-			ID       string `xml:"record_id"`
-			Type     string `xml:"type"`
-			Name     string `xml:"host"`
-			Value    string `xml:"value"`
-			TTL      int    `xml:"ttl"`
-			Priority int    `xml:"distance"`
-		} `xml:"reply>resource_record"`
+	grouped := make(map[string][]libdns.Record)
+	for _, record := range records {
+		recordType := strings.ToUpper(record.Type)
+		grouped[recordType] = append(grouped[recordType], record)
 	}
 
-	err = xml.Unmarshal(result, &resultObj)
+	return grouped, nil
+}
+
+// RecordMatches reports whether the zone currently holds a record with the
+// same type, name and value as record, such as an ACME challenge token
+// that should only be cleaned up if it hasn't been replaced since. record
+// is normalized as by NormalizeRecords first, so a quoted TXT value
+// matches the same value stored unquoted.
+func (p *Provider) RecordMatches(ctx context.Context, zone string, record libdns.Record) (bool, error) {
+	zone = getDomain(zone)
+
+	// TTLs play no part in matching, and mustn't fail under Strict.
+	record.TTL = 0
+	record, err := p.normalizeRecord(zone, record)
 	if err != nil {
-		log.Fatalf("didn't expect error: %s", err)
+		return false, err
 	}
 
-	var records []libdns.Record
+	records, err := p.GetRecordsFiltered(ctx, zone, record.Type)
+	if err != nil {
+		return false, err
+	}
 
-	for _, record := range resultObj.Records {
-		records = append(records, libdns.Record{
-			ID:       record.ID,
-			Type:     record.Type,
-			Name:     record.Name,
-			Value:    record.Value,
-			TTL:      time.Duration(record.TTL) * time.Second,
-			Priority: record.Priority,
-		})
+	for _, current := range records {
+		if sameRRset(zone, current, record) && current.Value == record.Value {
+			return true, nil
+		}
 	}
 
-	return records, nil
+	return false, nil
 }
 
-//AppendRecords adds records to the zone. It returns the records that were added.
+// AppendRecords adds records to the zone. It returns the records that were added.
+// An empty batch returns immediately without contacting the API. A record
+// repeating the type, name and value of an earlier one in the batch is
+// skipped.
 func (p *Provider) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	log.Println("AppendRecords", zone, records)
-	var appendedRecords []libdns.Record
+	if len(records) == 0 {
+		return nil, nil
+	}
 
-	for _, record := range records {
-		client := http.Client{}
+	zone = getDomain(zone)
+	p.logf("AppendRecords %s %v", zone, logRecords(records))
 
-		domain := getDomain(zone)
-		host := getHostname(zone, record.Name)
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
 
-		rrttl := ""
-		if record.TTL != time.Duration(0) {
-			rrttl = fmt.Sprintf("&rrttl=%d", int64(record.TTL/time.Second))
+	var existing []libdns.Record
+	if p.SkipExisting {
+		unlock, err := lockZone(ctx, zone)
+		if err != nil {
+			reportAllFailed(ctx, err, records...)
+			return nil, err
 		}
+		defer unlock()
 
-		rrdistance := ""
-		if record.Priority != 0 {
-			rrdistance = fmt.Sprintf("&rrdistance=%d", record.Priority)
+		current, err := p.GetRecords(ctx, zone)
+		if err != nil {
+			reportAllFailed(ctx, err, records...)
+			return nil, err
+		}
+		for _, record := range current {
+			record.ID = ""
+			existing = append(existing, record)
 		}
+	}
 
-		req_url := p.getApiHost() + "/dnsAddRecord?version=1&type=xml&key=" + p.APIToken + "&domain=" + domain + "&rrtype=" + record.Type + "&rrhost=" + host + "&rrvalue=" + record.Value + rrttl + rrdistance
-		req, err := http.NewRequest("GET", req_url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("Request error: " + p.getApiHost() + "/dnsAddRecord?version=1&type=xml&key=" + p.APIToken +
-				"&domain=" + domain + "&rrtype=" + record.Type + "&rrhost=" + host + "&rrvalue=" + record.Value + rrttl)
+	var appendedRecords []libdns.Record
+	var errs batchErrors
+	var seen []libdns.Record
+
+	for i, record := range records {
+		// Appending the same value twice would leave two identical records
+		// in the zone. IDs are ignored, since append always creates new
+		// records.
+		key := record
+		key.ID = ""
+		if duplicate(zone, seen, key) {
+			p.logf("AppendRecords: skipping duplicate %s record %s in batch", record.Type, record.Name)
+			reportSkipped(ctx, "duplicate of an earlier record in the batch", record)
+			continue
 		}
+		seen = append(seen, key)
 
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
+		if normalized, err := p.normalizeRecord(zone, key); err == nil && duplicate(zone, existing, normalized) {
+			p.logf("AppendRecords: skipping %s record %s already in zone", record.Type, record.Name)
+			reportSkipped(ctx, "already in the zone", record)
+			continue
 		}
-		defer resp.Body.Close()
 
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		record, err := p.appendRecord(ctx, zone, record)
 		if err != nil {
-			return nil, err
+			reportFailed(ctx, record, err)
+			if errs.add(err); p.stopBatch(ctx) {
+				reportSkipped(ctx, skippedAfterFailure, records[i+1:]...)
+				break
+			}
+			continue
 		}
+		reportSucceeded(ctx, record)
+		appendedRecords = append(appendedRecords, record)
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("HTTP error: Domain: %s; Record: %s, Status: %v; Body: %s",
-				getDomain(zone), getHostname(zone, record.Name), resp.StatusCode, string(bodyBytes))
-		}
+	if p.ReturnServerState && len(appendedRecords) > 0 {
+		appendedRecords = p.serverState(ctx, zone, appendedRecords)
+	}
+
+	return appendedRecords, errs.err()
+}
 
-		var reply struct {
-			Code   int    `xml:"reply>code"`
-			Detail string `xml:"reply>detail"`
+// zoneLocks holds a lock, a channel with room for one token, for each
+// zone appended to with SkipExisting.
+var zoneLocks sync.Map
+
+// lockZone takes the zone's lock, waiting for it no longer than ctx
+// allows, and returns the function releasing it.
+func lockZone(ctx context.Context, zone string) (func(), error) {
+	v, _ := zoneLocks.LoadOrStore(zone, make(chan struct{}, 1))
+	lock := v.(chan struct{})
+
+	select {
+	case lock <- struct{}{}:
+		return func() { <-lock }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// serverState returns records as currently stored in the zone, matched by
+// ID. Records that can't be found, or all of them if the zone can't be
+// fetched, are returned as given.
+func (p *Provider) serverState(ctx context.Context, zone string, records []libdns.Record) []libdns.Record {
+	current, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		p.logf("AppendRecords: could not fetch stored records: %v", err)
+		return records
+	}
+
+	stored := make([]libdns.Record, len(records))
+	for i, record := range records {
+		if server, ok := findRecordByID(current, record.ID); ok && record.ID != "" {
+			record = server
 		}
+		stored[i] = record
+	}
+	return stored
+}
+
+// appendRecord adds a single record for AppendRecords, returning it with
+// the ID namesilo assigned.
+func (p *Provider) appendRecord(ctx context.Context, zone string, record libdns.Record) (libdns.Record, error) {
+	record, err := p.normalizeRecord(zone, record)
+	if err != nil {
+		return record, err
+	}
+
+	domain := getDomain(zone)
+	host := getHostname(zone, record.Name)
+
+	params := url.Values{
+		"domain":  {domain},
+		"rrtype":  {record.Type},
+		"rrhost":  {host},
+		"rrvalue": {record.Value},
+	}
+	p.setOptionalParams(params, record)
 
-		err = xml.Unmarshal(bodyBytes, &reply)
+	// namesilo has no idempotency keys, so a retried append first
+	// checks whether the failed attempt created the record after all.
+	var reply apiReply
+	applied, err := p.retryCall(ctx, "dnsAddRecord", params, &reply, func() (bool, error) {
+		current, err := p.GetRecords(ctx, zone)
 		if err != nil {
-			return nil, fmt.Errorf("didn't expect error: %s", err)
+			return false, err
 		}
-
-		if reply.Code != 300 {
-			return nil, fmt.Errorf("API Append operation unsuccessful:\nDomain: %s\nHostname: %s\nReply code: %d\nDetails: %s",
-				getDomain(zone), getHostname(zone, record.Name), reply.Code, reply.Detail)
+		for _, c := range current {
+			if sameRRset(zone, c, record) && c.Value == record.Value {
+				reply.RecordID = c.ID
+				return true, nil
+			}
 		}
-		appendedRecords = append(appendedRecords, record)
+		return false, nil
+	})
+	if err != nil {
+		return record, withRecord(err, record)
 	}
 
-	return appendedRecords, nil
+	if applied {
+		p.logf("AppendRecords: %s record %s was created by an earlier attempt", record.Type, host)
+	} else if err := reply.err(domain, host); err != nil {
+		return record, withRecord(err, record)
+	}
+
+	if reply.RecordID != "" {
+		record.ID = reply.RecordID
+	}
+	return record, nil
 }
 
 // SetRecords sets the records in the zone, either by updating existing records or creating new ones.
-// It returns the updated records.
+// It returns the updated records. Records that already hold the desired values are left alone and
+// not returned, so an empty result means nothing changed, as are records given with an ID but
+// no value or TTL. An empty batch returns immediately without contacting the API.
 func (p *Provider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	log.Println("SetRecords", zone, records)
+	return p.setBatch(ctx, "SetRecords", zone, records, setAny)
+}
 
-	domain := getDomain(zone)
+// CreateRecords is SetRecords for records that must not exist yet. A
+// record given with an ID, or matching an existing record under
+// MatchStrategy, fails with ErrRecordExists instead of being updated.
+func (p *Provider) CreateRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return p.setBatch(ctx, "CreateRecords", zone, records, setCreate)
+}
 
-	currentRecords, err := p.GetRecords(ctx, zone)
+// UpdateRecords is SetRecords for records that must exist already. A
+// record given without ID that matches no existing record under
+// MatchStrategy fails with ErrRecordNotFound instead of being created.
+func (p *Provider) UpdateRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	return p.setBatch(ctx, "UpdateRecords", zone, records, setUpdate)
+}
+
+// setBatch runs setRecords for one of the public set methods.
+func (p *Provider) setBatch(ctx context.Context, operation, zone string, records []libdns.Record, intent setIntent) ([]libdns.Record, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	zone = getDomain(zone)
+	p.logf("%s %s %v", operation, zone, logRecords(records))
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	result, err := p.setRecords(ctx, zone, records, intent)
+	return append(result.Created, result.Updated...), err
+}
+
+// setIntent restricts setRecords to creating or to updating records.
+type setIntent int
+
+const (
+	setAny setIntent = iota
+	setCreate
+	setUpdate
+)
+
+// check returns an error if record, which exists in the zone or not,
+// may not be set under intent.
+func (intent setIntent) check(zone string, record libdns.Record, exists bool) error {
+	switch {
+	case intent == setCreate && exists:
+		return fmt.Errorf("%w: %s record %s in %s", ErrRecordExists, record.Type, record.Name, zone)
+	case intent == setUpdate && !exists:
+		return fmt.Errorf("%w: %s record %s in %s", ErrRecordNotFound, record.Type, record.Name, zone)
+	}
+	return nil
+}
+
+// batchContext derives the context for a batch operation, bounded by
+// BatchTimeout when set.
+func (p *Provider) batchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.BatchTimeout > 0 {
+		return context.WithTimeout(ctx, p.BatchTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// MatchStrategy controls how SetRecords pairs a record given without ID
+// with an existing record to update. Records given with an ID always
+// update the record holding that ID.
+type MatchStrategy int
+
+const (
+	// MatchTypeName updates an existing record of the same type and name,
+	// replacing its value. Setting a second value for a name therefore
+	// overwrites the first rather than adding to it.
+	MatchTypeName MatchStrategy = iota
+	// MatchID updates only records given with an ID; all others are
+	// appended, even where a record of the same type and name exists.
+	MatchID
+	// MatchTypeNameValue updates an existing record of the same type, name
+	// and value, so only its TTL or priority can change; records with a
+	// new value are appended alongside the existing ones.
+	MatchTypeNameValue
+)
+
+func (s MatchStrategy) String() string {
+	switch s {
+	case MatchTypeName:
+		return "type+name"
+	case MatchID:
+		return "id"
+	case MatchTypeNameValue:
+		return "type+name+value"
+	}
+	return fmt.Sprintf("MatchStrategy(%d)", int(s))
+}
+
+// matches reports whether desired, given without ID, is to update current.
+func (s MatchStrategy) matches(zone string, current, desired libdns.Record) bool {
+	switch s {
+	case MatchID:
+		return false
+	case MatchTypeNameValue:
+		return sameRRset(zone, current, desired) && current.Value == desired.Value
+	}
+	return sameRRset(zone, current, desired)
+}
+
+// setRecords implements SetRecords, CreateRecords and UpdateRecords,
+// reporting created and updated records separately.
+func (p *Provider) setRecords(ctx context.Context, zone string, records []libdns.Record, intent setIntent) (result SyncResult, err error) {
+	ctx, requests := CountRequests(ctx)
+	defer func() {
+		result.Requests = requests()
+	}()
+
+	currentRecords, err := p.zoneRecords(ctx, zone)
 	if err != nil {
-		return nil, err
+		reportAllFailed(ctx, err, records...)
+		return SyncResult{}, err
+	}
+
+	records = p.withoutSystemRecords(ctx, zone, currentRecords, records)
+	if len(records) == 0 {
+		return SyncResult{}, nil
 	}
 
 	var updateRecords []libdns.Record
 	var appendRecords []libdns.Record
+	var errs batchErrors
+	var seen []libdns.Record
 
-	for _, record := range records {
+	// Records matched by type and name are taken out of the candidates so
+	// that no two records in the batch update the same one. IDs are
+	// checked against the zone as fetched, which includes them.
+	candidates := append([]libdns.Record(nil), currentRecords...)
+
+	for i, record := range records {
+		record, err = p.prepareSetRecord(zone, currentRecords, record)
+		if err != nil {
+			reportFailed(ctx, record, err)
+			if errs.add(err); !p.ContinueOnError {
+				reportSkipped(ctx, skippedAfterFailure, records[i+1:]...)
+				return SyncResult{}, errs.err()
+			}
+			continue
+		}
+
+		// The same record may appear twice under different spellings of
+		// its name, such as "@" and the zone itself for the apex.
+		if duplicate(zone, seen, record) {
+			p.debugf("SetRecords: type=%s name=%s match=batch action=none", record.Type, record.Name)
+			reportSkipped(ctx, "duplicate of an earlier record in the batch", record)
+			continue
+		}
+		seen = append(seen, record)
+
+		if record.ID != "" && record.Value == "" && record.TTL == 0 {
+			// An ID alone would send neither rrvalue nor rrttl, which
+			// could blank the record rather than change nothing.
+			p.debugf("SetRecords: type=%s name=%s match=id id=%s action=none (nothing to update)", record.Type, record.Name, record.ID)
+			reportSkipped(ctx, "no value or TTL to update", record)
+			continue
+		}
+
+		match := -1
 		if record.ID == "" {
-			for i, currentRecord := range currentRecords {
-				if currentRecord.Type == record.Type && getHostname(zone, currentRecord.Name) == getHostname(zone, record.Name) {
-					currentRecords = append(currentRecords[:i], currentRecords[i+1:]...)
-					record.ID = currentRecord.ID
-					updateRecords = append(updateRecords, record)
+			for j, candidate := range candidates {
+				if p.MatchStrategy.matches(zone, candidate, record) {
+					match = j
 					break
 				}
-				if i == len(currentRecords)-1 {
-					appendRecords = append(appendRecords, record)
-				}
 			}
-		} else {
+		}
+		if err := intent.check(zone, record, record.ID != "" || match >= 0); err != nil {
+			reportFailed(ctx, record, err)
+			if errs.add(err); !p.ContinueOnError {
+				reportSkipped(ctx, skippedAfterFailure, records[i+1:]...)
+				return SyncResult{}, errs.err()
+			}
+			continue
+		}
+
+		if record.ID != "" {
+			if current, ok := findRecordByID(currentRecords, record.ID); ok && recordUnchanged(zone, current, record) {
+				p.debugf("SetRecords: type=%s name=%s match=id id=%s action=none", record.Type, record.Name, record.ID)
+				reportSkipped(ctx, "unchanged", record)
+				continue
+			}
+			p.debugf("SetRecords: type=%s name=%s match=id id=%s action=update", record.Type, record.Name, record.ID)
 			updateRecords = append(updateRecords, record)
+			continue
 		}
 
+		if match >= 0 {
+			currentRecord := candidates[match]
+			candidates = append(candidates[:match], candidates[match+1:]...)
+			record.ID = currentRecord.ID
+			if recordUnchanged(zone, currentRecord, record) {
+				p.debugf("SetRecords: type=%s name=%s match=%s id=%s action=none", record.Type, record.Name, p.MatchStrategy, record.ID)
+				reportSkipped(ctx, "unchanged", record)
+				continue
+			}
+			p.debugf("SetRecords: type=%s name=%s match=%s id=%s action=update", record.Type, record.Name, p.MatchStrategy, record.ID)
+			updateRecords = append(updateRecords, record)
+			continue
+		}
+
+		p.debugf("SetRecords: type=%s name=%s match=none action=append", record.Type, record.Name)
+		appendRecords = append(appendRecords, record)
 	}
 
-	var updatedRecords []libdns.Record
-	appendedRecords, err := p.AppendRecords(ctx, zone, appendRecords)
-	if err != nil {
-		return nil, err
+	var appendedRecords []libdns.Record
+	if len(appendRecords) > 0 {
+		appendedRecords, err = p.AppendRecords(ctx, zone, appendRecords)
+		if err != nil {
+			if errs.add(err); p.stopBatch(ctx) {
+				reportSkipped(ctx, skippedAfterFailure, updateRecords...)
+				return SyncResult{Created: appendedRecords}, errs.err()
+			}
+		}
 	}
-	copy(updatedRecords[:], appendedRecords[:])
 
-	for _, record := range updateRecords {
-		log.Println("updating record id " + record.ID)
-		rrttl := ""
-		if record.TTL != time.Duration(0) {
-			rrttl = fmt.Sprintf("&rrttl=%d", int64(record.TTL/time.Second))
+	var updatedRecords []libdns.Record
+
+	for i, record := range updateRecords {
+		p.logf("updating record id %s", record.ID)
+		err = p.updateRecord(ctx, zone, record)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Code == codeDNSModification && p.PropagationPollTimeout > 0 {
+			// The ID may belong to a record appended moments ago that
+			// namesilo doesn't know about yet; wait for it and try again.
+			id := record.ID
+			_, err = p.pollRecords(ctx, zone, func(records []libdns.Record) bool {
+				_, ok := findRecordByID(records, id)
+				return ok
+			})
+			if err == nil {
+				err = p.updateRecord(ctx, zone, record)
+			}
+		}
+		if err != nil {
+			reportFailed(ctx, record, err)
+			if errs.add(err); p.stopBatch(ctx) {
+				reportSkipped(ctx, skippedAfterFailure, updateRecords[i+1:]...)
+				break
+			}
+			continue
 		}
 
-		rrdistance := ""
-		if record.Priority != 0 {
-			rrdistance = fmt.Sprintf("&rrdistance=%d", record.Priority)
+		reportSucceeded(ctx, record)
+		updatedRecords = append(updatedRecords, record)
+	}
+
+	return SyncResult{Created: appendedRecords, Updated: updatedRecords}, errs.err()
+}
+
+// duplicate reports whether records holds one with the same ID, or
+// without ID the same type, name and value, as record.
+func duplicate(zone string, records []libdns.Record, record libdns.Record) bool {
+	for _, r := range records {
+		if r.ID != "" || record.ID != "" {
+			if r.ID == record.ID {
+				return true
+			}
+			continue
+		}
+		if sameRRset(zone, r, record) && r.Value == record.Value {
+			return true
 		}
+	}
+	return false
+}
 
-		req_url := p.getApiHost() + "/dnsUpdateRecord?version=1&type=xml&key=" + p.APIToken + "&domain=" + domain +
-			"&rrid=" + record.ID + "&rrhost=" + getHostname(zone, record.Name) + "&rrvalue=" + record.Value +
-			rrdistance + rrttl
-		req, err := http.NewRequest("GET", req_url, nil)
-		if err != nil {
-			return nil, err
+// prepareSetRecord validates and normalizes record for setRecords and
+// checks that its ID, if any, is still in the zone.
+func (p *Provider) prepareSetRecord(zone string, currentRecords []libdns.Record, record libdns.Record) (libdns.Record, error) {
+	record, err := p.normalizeRecord(zone, record)
+	if err != nil {
+		return record, err
+	}
+
+	if _, ok := findRecordByID(currentRecords, record.ID); record.ID != "" && !ok {
+		if p.AppendStaleIDs {
+			p.debugf("SetRecords: type=%s name=%s match=stale-id id=%s", record.Type, record.Name, record.ID)
+			record.ID = ""
+		} else if p.PropagationPollTimeout == 0 {
+			return record, fmt.Errorf("%w: ID %s of %s record %s in %s", ErrRecordNotFound, record.ID, record.Type, record.Name, zone)
 		}
+	}
+
+	return record, nil
+}
 
-		client := http.Client{}
-		resp, err := client.Do(req)
+// setOptionalParams adds the rrttl and rrdistance parameters for record
+// when they apply. Priority is always sent for types that have one, since
+// namesilo stores a distance of 0 as given and substitutes its own default
+// when the parameter is missing; it is never sent for other types.
+func (p *Provider) setOptionalParams(params url.Values, record libdns.Record) {
+	if record.TTL != time.Duration(0) {
+		params.Set("rrttl", strconv.FormatInt(ttlSeconds(record.TTL), 10))
+	}
+	if supportsPriority(record.Type) {
+		params.Set("rrdistance", strconv.Itoa(record.Priority))
+	} else if record.Priority != 0 {
+		p.debugf("ignoring priority %d on %s record %s", record.Priority, record.Type, record.Name)
+	}
+}
+
+// supportsPriority reports whether namesilo accepts rrdistance for
+// records of the given type.
+func supportsPriority(recordType string) bool {
+	switch strings.ToUpper(recordType) {
+	case "MX", "SRV":
+		return true
+	}
+	return false
+}
+
+// updateRecord issues a single dnsUpdateRecord call. A record without a
+// value is refused, as namesilo would blank out the existing record's
+// value rather than reject the update.
+func (p *Provider) updateRecord(ctx context.Context, zone string, record libdns.Record) error {
+	domain := getDomain(zone)
+	host := getHostname(zone, record.Name)
+
+	if strings.TrimSpace(record.Value) == "" {
+		return fmt.Errorf("refusing to update %s record %s (ID %s) in %s with an empty value", record.Type, record.Name, record.ID, domain)
+	}
+
+	params := url.Values{
+		"domain":  {domain},
+		"rrid":    {record.ID},
+		"rrhost":  {host},
+		"rrvalue": {record.Value},
+	}
+	p.setOptionalParams(params, record)
+
+	var reply apiReply
+	if err := p.call(ctx, "dnsUpdateRecord", params, &reply); err != nil {
+		return withRecord(err, record)
+	}
+
+	return withRecord(reply.err(domain, host), record)
+}
+
+// DeleteRecords deletes the records from the zone. It returns the records that were deleted.
+// An empty batch returns immediately without contacting the API. Records
+// given without ID are matched by type, name and value after being
+// normalized as by NormalizeRecords; records with an ID are deleted by ID
+// as given.
+func (p *Provider) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	zone = getDomain(zone)
+	p.logf("DeleteRecords %s %v", zone, logRecords(records))
+
+	records, err := p.normalizeDeleteRecords(ctx, zone, records)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	domain := getDomain(zone)
+
+	currentRecords, err := p.zoneRecords(ctx, zone)
+	if err != nil {
+		reportAllFailed(ctx, err, records...)
+		return nil, err
+	}
+
+	records = p.withoutSystemRecords(ctx, zone, currentRecords, records)
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	deleteRecords, missing := matchDeleteRecords(domain, currentRecords, records)
+	if len(missing) > 0 && p.PropagationPollTimeout > 0 {
+		// Records appended moments ago may not be listed yet.
+		currentRecords, err = p.pollRecords(ctx, zone, func(records []libdns.Record) bool {
+			_, stillMissing := matchDeleteRecords(domain, records, missing)
+			return len(stillMissing) == 0
+		})
 		if err != nil {
+			reportAllFailed(ctx, err, records...)
 			return nil, err
 		}
-		defer resp.Body.Close()
+		deleteRecords, missing = matchDeleteRecords(domain, currentRecords, records)
+	}
+	reportSkipped(ctx, "not found in the zone", missing...)
 
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
+	return p.deleteRecords(ctx, zone, deleteRecords)
+}
+
+// normalizeDeleteRecords returns a copy of records with those without ID
+// normalized, so that an MX value with a trailing dot or a lowercase type
+// still matches the record GetRecords lists. The TTL plays no part in
+// matching, so it is left out rather than validated. An invalid record
+// fails the whole batch, before any request is made.
+func (p *Provider) normalizeDeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	normalized := make([]libdns.Record, len(records))
+	for i, record := range records {
+		if record.ID != "" {
+			normalized[i] = record
+			continue
+		}
+		record.TTL = 0
+		record, err := p.normalizeRecord(zone, record)
 		if err != nil {
+			reportSkipped(ctx, skippedInvalidBatch, records[:i]...)
+			reportFailed(ctx, records[i], err)
+			reportSkipped(ctx, skippedInvalidBatch, records[i+1:]...)
 			return nil, err
 		}
+		normalized[i] = record
+	}
+	return normalized, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("HTTP error: Domain: %s; Records: %v, Status: %v; Body: %s",
-				zone, currentRecords, resp.StatusCode, string(bodyBytes))
-		}
+// DeleteRecordsMatching deletes every record in the zone for which match
+// returns true. It returns the records that were deleted.
+func (p *Provider) DeleteRecordsMatching(ctx context.Context, zone string, match func(libdns.Record) bool) ([]libdns.Record, error) {
+	zone = getDomain(zone)
+	p.logf("DeleteRecordsMatching %s", zone)
 
-		var reply struct {
-			Code   int    `xml:"reply>code"`
-			Detail string `xml:"reply>detail"`
-		}
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
 
-		err = xml.Unmarshal(bodyBytes, &reply)
-		if err != nil {
-			return nil, fmt.Errorf("didn't expect error: %s", err)
+	currentRecords, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleteRecords []libdns.Record
+	for _, record := range currentRecords {
+		if match(record) {
+			deleteRecords = append(deleteRecords, record)
 		}
+	}
+
+	return p.deleteRecords(ctx, zone, deleteRecords)
+}
+
+// deleteRecords deletes the given records, which must all carry an ID.
+// namesilo's dnsDeleteRecord accepts a single rrid and the API has no bulk
+// delete operation, so one request is issued per record.
+func (p *Provider) deleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	domain := getDomain(zone)
+
+	var deletedRecords []libdns.Record
 
-		if reply.Code != 300 {
-			return nil, fmt.Errorf("API Update operation failed:\nDomain: %s\nRecord: %s\nReply code: %d\nStatus: %s",
-				getDomain(zone), getHostname(zone, record.Name), reply.Code, reply.Detail)
+	var errs batchErrors
+
+	for i, record := range records {
+		if err := p.deleteRecord(ctx, domain, record.ID, getHostname(zone, record.Name)); err != nil {
+			err = withRecord(err, record)
+			reportFailed(ctx, record, err)
+			if errs.add(err); p.stopBatch(ctx) {
+				reportSkipped(ctx, skippedAfterFailure, records[i+1:]...)
+				break
+			}
+			continue
 		}
 
-		updatedRecords = append(updatedRecords, record)
+		reportSucceeded(ctx, record)
+		deletedRecords = append(deletedRecords, record)
 	}
 
-	return updatedRecords, nil
+	return deletedRecords, errs.err()
 }
 
-// DeleteRecords deletes the records from the zone. It returns the records that were deleted.
-func (p *Provider) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	log.Println("DeleteRecords", zone, records)
+// deleteRecord issues a single dnsDeleteRecord call. host is only used to
+// describe the record in errors.
+func (p *Provider) deleteRecord(ctx context.Context, domain, id, host string) error {
+	var reply apiReply
+	if err := p.call(ctx, "dnsDeleteRecord", url.Values{"domain": {domain}, "rrid": {id}}, &reply); err != nil {
+		return err
+	}
+
+	return reply.err(domain, host)
+}
+
+// DeleteRecordByID deletes the record with the given ID from the zone
+// without looking it up first. If namesilo reports the record doesn't
+// exist, it returns nil when IgnoreMissing is set and an error wrapping
+// ErrRecordNotFound otherwise.
+func (p *Provider) DeleteRecordByID(ctx context.Context, zone, id string) error {
+	p.logf("DeleteRecordByID %s %s", zone, id)
 
 	domain := getDomain(zone)
 
-	currentRecords, err := p.GetRecords(ctx, zone)
-	if err != nil {
-		return nil, err
+	err := p.deleteRecord(ctx, domain, id, "")
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == codeDNSModification {
+		if p.IgnoreMissing {
+			p.debugf("DeleteRecordByID: record %s not found in %s, ignoring", id, domain)
+			return nil
+		}
+		return fmt.Errorf("%w: ID %s in %s: %s", ErrRecordNotFound, id, domain, apiErr.Detail)
 	}
 
-	var deletedRecords []libdns.Record
+	return err
+}
+
+// matchDeleteRecords resolves the records to delete against the current
+// zone contents. Records without an ID are matched by type, hostname and
+// value; those that can't be found are returned as missing.
+func matchDeleteRecords(domain string, currentRecords, records []libdns.Record) ([]libdns.Record, []libdns.Record) {
+	candidates := append([]libdns.Record(nil), currentRecords...)
+
 	var deleteRecords []libdns.Record
+	var missing []libdns.Record
 
 	for _, record := range records {
-		if record.ID == "" {
-			for i, currentRecord := range currentRecords {
-				if currentRecord.Type == record.Type && getHostname(domain, currentRecord.Name) == getHostname(domain, record.Name) && currentRecord.Value == record.Value {
-					currentRecords = append(currentRecords[:i], currentRecords[i+1:]...)
-					deleteRecords = append(deleteRecords, currentRecord)
-					break
-				}
-			}
-		} else {
+		if record.ID != "" {
 			deleteRecords = append(deleteRecords, record)
+			continue
+		}
+		value := record.Value
+		if isTXT(record.Type) {
+			value = unquoteTXT(value)
+		}
+		found := false
+		for i, currentRecord := range candidates {
+			if currentRecord.Type == record.Type && getHostname(domain, currentRecord.Name) == getHostname(domain, record.Name) && currentRecord.Value == value {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				deleteRecords = append(deleteRecords, currentRecord)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, record)
 		}
 	}
 
-	for _, record := range deleteRecords {
-		req_url := p.getApiHost() + "/dnsDeleteRecord?version=1&type=xml&key=" + p.APIToken + "&domain=" + domain + "&rrid=" + record.ID
-		req, err := http.NewRequest("GET", req_url, nil)
-		if err != nil {
-			return nil, err
-		}
+	return deleteRecords, missing
+}
 
-		client := http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return nil, err
+// isSystemRecord reports whether record is one of the zone's SOA or apex NS
+// records, which are managed by namesilo rather than by the user.
+func isSystemRecord(zone string, record libdns.Record) bool {
+	switch strings.ToUpper(strings.TrimSpace(record.Type)) {
+	case "SOA":
+		return true
+	case "NS":
+		return getHostname(zone, record.Name) == ""
+	}
+	return false
+}
+
+// withoutSystemRecords drops system records from records when
+// ExcludeSystemRecords is set. A record given by ID is judged by the type
+// and name it has in currentRecords, the zone as zoneRecords fetched it,
+// rather than by whatever else the caller filled in.
+func (p *Provider) withoutSystemRecords(ctx context.Context, zone string, currentRecords, records []libdns.Record) []libdns.Record {
+	if !p.ExcludeSystemRecords {
+		return records
+	}
+	var filtered []libdns.Record
+	for _, record := range records {
+		stored := record
+		if record.ID != "" {
+			if current, ok := findRecordByID(currentRecords, record.ID); ok {
+				stored = current
+			}
 		}
-		defer resp.Body.Close()
+		if isSystemRecord(zone, stored) {
+			p.debugf("skipping system record type=%s name=%s", stored.Type, stored.Name)
+			reportSkipped(ctx, "system record", record)
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	return filtered
+}
 
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
+func findRecordByID(records []libdns.Record, id string) (libdns.Record, bool) {
+	for _, record := range records {
+		if record.ID == id {
+			return record, true
 		}
+	}
+	return libdns.Record{}, false
+}
+
+// recordUnchanged reports whether setting desired over current would be a
+// no-op. A zero TTL in desired leaves the current TTL as is.
+func recordUnchanged(zone string, current, desired libdns.Record) bool {
+	if current.Type != desired.Type || current.Value != desired.Value ||
+		getHostname(zone, current.Name) != getHostname(zone, desired.Name) {
+		return false
+	}
+	if desired.TTL != 0 && desired.TTL != current.TTL {
+		return false
+	}
+	if supportsPriority(desired.Type) && desired.Priority != current.Priority {
+		return false
+	}
+	return true
+}
+
+// pollRecords re-fetches the zone with jittered exponential backoff until
+// found reports true or PropagationPollTimeout elapses, and returns the most
+// recently fetched records either way.
+func (p *Provider) pollRecords(ctx context.Context, zone string, found func([]libdns.Record) bool) ([]libdns.Record, error) {
+	clock := p.clock()
+	deadline := clock.Now().Add(p.PropagationPollTimeout)
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("HTTP error: Domain: %s; Records: %v, Status: %v; Body: %s",
-				zone, currentRecords, resp.StatusCode, string(bodyBytes))
+	var records []libdns.Record
+	for attempt := 0; ; attempt++ {
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			return records, nil
+		}
+		delay := backoff(attempt, 250*time.Millisecond, 5*time.Second)
+		if delay > remaining {
+			delay = remaining
 		}
 
-		var reply struct {
-			Code   int    `xml:"reply>code"`
-			Detail string `xml:"reply>detail"`
+		if err := p.wait(ctx, delay); err != nil {
+			return nil, err
 		}
 
-		err = xml.Unmarshal(bodyBytes, &reply)
+		var err error
+		records, err = p.GetRecords(ctx, zone)
 		if err != nil {
-			return nil, fmt.Errorf("didn't expect error: %s", err)
+			return nil, err
 		}
-
-		if reply.Code != 300 {
-			return nil, fmt.Errorf("API Delete operation unsuccessful:\nDomain: %s\nRecord: %s\nReply code: %d\nStatus: %s",
-				getDomain(zone), getHostname(zone, record.Name), reply.Code, reply.Detail)
+		if found(records) {
+			return records, nil
 		}
-
-		deletedRecords = append(deletedRecords, record)
 	}
-
-	return deletedRecords, nil
 }
 
-// Interface guards
+// Interface guards. These are all the interfaces libdns v0.2.1 defines;
+// guard any further ones, such as ZoneLister, as libdns gains them and the
+// provider implements them.
 var (
 	_ libdns.RecordGetter   = (*Provider)(nil)
 	_ libdns.RecordAppender = (*Provider)(nil)
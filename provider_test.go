@@ -0,0 +1,1491 @@
+package namesilo
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// testZone is the zone the fake API serves.
+const testZone = "example.com"
+
+// testToken is the API key test providers send.
+const testToken = "secret-token"
+
+// fakeRequest is a request received by fakeNamesilo.
+type fakeRequest struct {
+	Operation string
+	Method    string
+	URL       *url.URL
+	Header    http.Header
+
+	// Params holds the query and form parameters together.
+	Params url.Values
+}
+
+// fakeNamesilo is an in-memory stand-in for the namesilo API. It serves
+// dnsListRecords, dnsAddRecord, dnsUpdateRecord and dnsDeleteRecord for a
+// single domain, testZone unless changed; tests replace or add operations
+// with handle.
+type fakeNamesilo struct {
+	t      *testing.T
+	server *httptest.Server
+
+	// domain is the zone served. Set it before making requests.
+	domain string
+
+	mu       sync.Mutex
+	records  []NamesiloRecord
+	nextID   int
+	requests []fakeRequest
+	handlers map[string]http.HandlerFunc
+
+	// hidden holds, by record ID, for how many more listings a record
+	// stays invisible, as records do for a while after being appended.
+	// Updates and deletes of a hidden record fail with reply code 280.
+	hidden map[string]int
+}
+
+// newFakeNamesilo starts a fake API holding records, which is shut down
+// when the test ends.
+func newFakeNamesilo(t *testing.T, records ...NamesiloRecord) *fakeNamesilo {
+	t.Helper()
+
+	f := &fakeNamesilo{
+		t:        t,
+		domain:   testZone,
+		records:  records,
+		nextID:   100,
+		handlers: make(map[string]http.HandlerFunc),
+		hidden:   make(map[string]int),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// provider returns a Provider talking to the fake API.
+func (f *fakeNamesilo) provider() *Provider {
+	return &Provider{
+		APIToken: testToken,
+		Logger:   testLogger{f.t},
+		apiHost:  f.server.URL,
+	}
+}
+
+// handle replaces the fake's handling of operation.
+func (f *fakeNamesilo) handle(operation string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[operation] = handler
+}
+
+// hide keeps the record with the given ID out of the next n listings.
+func (f *fakeNamesilo) hide(id string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hidden[id] = n
+}
+
+// zone returns the records the fake currently holds.
+func (f *fakeNamesilo) zone() []NamesiloRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]NamesiloRecord(nil), f.records...)
+}
+
+// received returns the requests made for operation, or all requests if
+// operation is empty.
+func (f *fakeNamesilo) received(operation string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var requests []fakeRequest
+	for _, req := range f.requests {
+		if operation == "" || req.Operation == operation {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// count returns how many requests were made for operation.
+func (f *fakeNamesilo) count(operation string) int {
+	return len(f.received(operation))
+}
+
+func (f *fakeNamesilo) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		f.t.Errorf("parsing request: %v", err)
+	}
+	operation := path.Base(r.URL.Path)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{
+		Operation: operation,
+		Method:    r.Method,
+		URL:       r.URL,
+		Header:    r.Header.Clone(),
+		Params:    r.Form,
+	})
+	handler := f.handlers[operation]
+	f.mu.Unlock()
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	f.serveDefault(w, r)
+}
+
+// serveDefault handles the record operations against the fake's zone.
+func (f *fakeNamesilo) serveDefault(w http.ResponseWriter, r *http.Request) {
+	operation := path.Base(r.URL.Path)
+	if key := r.Form.Get("key"); key != testToken {
+		writeReply(w, operation, codeInvalidAPIKey, "Invalid API Key", "")
+		return
+	}
+	if domain := r.Form.Get("domain"); domain != f.domain {
+		writeReply(w, operation, codeDomainNotActive, "Invalid Domain", "")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch operation {
+	case "dnsListRecords":
+		var body strings.Builder
+		for _, record := range f.records {
+			if f.hidden[record.RecordID] > 0 {
+				continue
+			}
+			body.WriteString(recordXML(record))
+		}
+		for id, n := range f.hidden {
+			if n > 0 {
+				f.hidden[id] = n - 1
+			}
+		}
+		writeReply(w, operation, codeSuccess, "success", body.String())
+
+	case "dnsAddRecord":
+		f.nextID++
+		record := NamesiloRecord{
+			RecordID: strconv.Itoa(f.nextID),
+			Type:     r.Form.Get("rrtype"),
+			Host:     hostIn(f.domain, r.Form.Get("rrhost")),
+			Value:    r.Form.Get("rrvalue"),
+			TTL:      7207,
+		}
+		if ttl := r.Form.Get("rrttl"); ttl != "" {
+			record.TTL, _ = strconv.Atoi(ttl)
+		}
+		if distance := r.Form.Get("rrdistance"); distance != "" {
+			record.Distance, _ = strconv.Atoi(distance)
+		}
+		f.records = append(f.records, record)
+		writeReply(w, operation, codeSuccess, "success", "<record_id>"+record.RecordID+"</record_id>")
+
+	case "dnsUpdateRecord":
+		i := f.indexOf(r.Form.Get("rrid"))
+		if i < 0 {
+			writeReply(w, operation, codeDNSModification, "Record ID does not exist", "")
+			return
+		}
+		record := &f.records[i]
+		record.Host = hostIn(f.domain, r.Form.Get("rrhost"))
+		record.Value = r.Form.Get("rrvalue")
+		if ttl := r.Form.Get("rrttl"); ttl != "" {
+			record.TTL, _ = strconv.Atoi(ttl)
+		}
+		if distance := r.Form.Get("rrdistance"); distance != "" {
+			record.Distance, _ = strconv.Atoi(distance)
+		}
+		writeReply(w, operation, codeSuccess, "success", "<record_id>"+record.RecordID+"</record_id>")
+
+	case "dnsDeleteRecord":
+		i := f.indexOf(r.Form.Get("rrid"))
+		if i < 0 {
+			writeReply(w, operation, codeDNSModification, "Record ID does not exist", "")
+			return
+		}
+		f.records = append(f.records[:i], f.records[i+1:]...)
+		writeReply(w, operation, codeSuccess, "success", "")
+
+	default:
+		f.t.Errorf("unexpected %s request", operation)
+		http.NotFound(w, r)
+	}
+}
+
+// indexOf returns the position of the visible record with the given ID,
+// or -1. f.mu must be held.
+func (f *fakeNamesilo) indexOf(id string) int {
+	if f.hidden[id] > 0 {
+		return -1
+	}
+	for i, record := range f.records {
+		if record.RecordID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// hostIn returns the host namesilo lists for rrhost in zone.
+func hostIn(zone, rrhost string) string {
+	if rrhost == "" {
+		return zone
+	}
+	return rrhost + "." + zone
+}
+
+// writeReply writes a namesilo XML reply with the given code and detail,
+// and body added inside the reply element.
+func writeReply(w http.ResponseWriter, operation string, code int, detail, body string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<namesilo><request><operation>%s</operation><ip>127.0.0.1</ip></request><reply><code>%d</code><detail>%s</detail>%s</reply></namesilo>`,
+		operation, code, escapeXML(detail), body)
+}
+
+// recordXML formats record as a dnsListRecords resource_record element.
+func recordXML(record NamesiloRecord) string {
+	return fmt.Sprintf("<resource_record><record_id>%s</record_id><type>%s</type><host>%s</host><value>%s</value><ttl>%d</ttl><distance>%d</distance></resource_record>",
+		escapeXML(record.RecordID), escapeXML(record.Type), escapeXML(record.Host), escapeXML(record.Value), record.TTL, record.Distance)
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// nsRecord returns a record as namesilo lists it in testZone, with host
+// relative to the zone.
+func nsRecord(id, recordType, host, value string) NamesiloRecord {
+	return NamesiloRecord{RecordID: id, Type: recordType, Host: hostIn(testZone, host), Value: value, TTL: 3600}
+}
+
+// testLogger sends the provider's log output to the test log.
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Printf(format string, v ...interface{}) {
+	l.t.Helper()
+	l.t.Logf(format, v...)
+}
+
+// instantClock is a Clock whose waits end at once, moving its time
+// forward by the length of each wait and recording it.
+type instantClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *instantClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *instantClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// waited returns the waits made so far.
+func (c *instantClock) waited() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+func TestSetRecordsWaitsForAppendedRecord(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "TXT", "_acme-challenge", "old"))
+	p := f.provider()
+	p.PropagationPollTimeout = 10 * time.Second
+	p.Clock = &instantClock{}
+	ctx := context.Background()
+
+	appended, err := p.AppendRecords(ctx, testZone, []libdns.Record{{Type: "TXT", Name: "www", Value: "new"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := appended[0].ID
+	f.hide(id, 2)
+
+	updated, err := p.SetRecords(ctx, testZone, []libdns.Record{{ID: id, Type: "TXT", Name: "www", Value: "newer"}})
+	if err != nil {
+		t.Fatalf("SetRecords: %v", err)
+	}
+	if len(updated) != 1 || updated[0].Value != "newer" {
+		t.Errorf("updated %v, want the record with its new value", updated)
+	}
+	if n := f.count("dnsUpdateRecord"); n != 2 {
+		t.Errorf("sent %d updates, want a failed one and a retry once the record was listed", n)
+	}
+}
+
+func TestDeleteRecordsWaitsForAppendedRecord(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	p.PropagationPollTimeout = 10 * time.Second
+	p.Clock = &instantClock{}
+	ctx := context.Background()
+
+	record := libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token"}
+	appended, err := p.AppendRecords(ctx, testZone, []libdns.Record{record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.hide(appended[0].ID, 3)
+
+	deleted, err := p.DeleteRecords(ctx, testZone, []libdns.Record{record})
+	if err != nil {
+		t.Fatalf("DeleteRecords: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != appended[0].ID {
+		t.Errorf("deleted %v, want the appended record", deleted)
+	}
+	if zone := f.zone(); len(zone) != 0 {
+		t.Errorf("zone still holds %v", zone)
+	}
+}
+
+func TestDeleteRecordsWithoutPollingMissesHiddenRecord(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	ctx := context.Background()
+
+	record := libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token"}
+	appended, err := p.AppendRecords(ctx, testZone, []libdns.Record{record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.hide(appended[0].ID, 1)
+
+	deleted, err := p.DeleteRecords(ctx, testZone, []libdns.Record{record})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("deleted %v without polling, want nothing", deleted)
+	}
+	if n := f.count("dnsListRecords"); n != 1 {
+		t.Errorf("listed the zone %d times, want 1", n)
+	}
+}
+
+// captureLogger collects log lines.
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+// contains reports whether a line containing s was logged.
+func (l *captureLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetRecordsLogsClassification(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "TXT", "info", "hello"),
+	)
+	logger := &captureLogger{}
+	p := f.provider()
+	p.Logger = logger
+	p.Debug = true
+
+	_, err := p.SetRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.2"},
+		{ID: "2", Type: "TXT", Name: "info", Value: "bye"},
+		{Type: "AAAA", Name: "www", Value: "2001:db8::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"type=A name=www match=type+name id=1 action=update",
+		"type=TXT name=info match=id id=2 action=update",
+		"type=AAAA name=www match=none action=append",
+	} {
+		if !logger.contains(want) {
+			t.Errorf("log lacks %q; got:\n%s", want, strings.Join(logger.lines, "\n"))
+		}
+	}
+}
+
+func TestSetRecordsLogsNothingWithoutDebug(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	logger := &captureLogger{}
+	p := f.provider()
+	p.Logger = logger
+
+	if _, err := p.SetRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if logger.contains("action=") {
+		t.Errorf("classification logged without Debug:\n%s", strings.Join(logger.lines, "\n"))
+	}
+}
+
+func TestDeleteRecordsMatching(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "A", "old", "198.51.100.7"),
+		nsRecord("3", "AAAA", "old", "2001:db8::7"),
+		nsRecord("4", "CNAME", "legacy", "old.example.com"),
+		nsRecord("5", "MX", "", "198.51.100.7"),
+	)
+	p := f.provider()
+
+	deleted, err := p.DeleteRecordsMatching(context.Background(), testZone, func(record libdns.Record) bool {
+		return record.Type == "A" && record.Value == "198.51.100.7"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0].ID != "2" {
+		t.Errorf("deleted %v, want record 2 only", deleted)
+	}
+	for _, req := range f.received("dnsDeleteRecord") {
+		if id := req.Params.Get("rrid"); id != "2" {
+			t.Errorf("deleted record %s", id)
+		}
+	}
+	if n := len(f.zone()); n != 4 {
+		t.Errorf("zone holds %d records, want 4", n)
+	}
+}
+
+func TestDeleteRecordsOneRequestPerRecord(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "TXT", "a", "one"),
+		nsRecord("2", "TXT", "b", "two"),
+		nsRecord("3", "TXT", "c", "three"),
+	)
+
+	deleted, err := f.provider().DeleteRecords(context.Background(), testZone, []libdns.Record{
+		{ID: "1"}, {ID: "2"}, {ID: "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 3 {
+		t.Errorf("deleted %d records, want 3", len(deleted))
+	}
+
+	requests := f.received("dnsDeleteRecord")
+	if len(requests) != 3 {
+		t.Fatalf("sent %d delete requests, want one per record", len(requests))
+	}
+	for _, req := range requests {
+		if ids := req.Params["rrid"]; len(ids) != 1 {
+			t.Errorf("delete request carries rrid %v, want a single ID", ids)
+		}
+	}
+}
+
+func TestGetHostname(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www", "www"},
+		{"www.example.com", "www"},
+		{"www.example.com.", "www"},
+		{"WWW.Example.COM.", "www"},
+		{"_acme-challenge.sub", "_acme-challenge.sub"},
+		{"example.com", ""},
+		{"example.com.", ""},
+		{"@", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		for _, zone := range []string{"example.com", "example.com."} {
+			if got := getHostname(zone, tt.name); got != tt.want {
+				t.Errorf("getHostname(%q, %q) = %q, want %q", zone, tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestExcludeSystemRecordsProtectsRecordsByID(t *testing.T) {
+	zone := []NamesiloRecord{
+		nsRecord("1", "NS", "", "ns1.dnsowl.com"),
+		nsRecord("2", "TXT", "keep", "value"),
+	}
+
+	tests := []struct {
+		name   string
+		record libdns.Record
+	}{
+		{"by ID", libdns.Record{ID: "1"}},
+		{"by ID with another type", libdns.Record{ID: "1", Type: "TXT", Name: "x"}},
+		{"lowercase type", libdns.Record{Type: "ns", Name: "@", Value: "ns1.dnsowl.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t, zone...)
+			p := f.provider()
+			p.ExcludeSystemRecords = true
+
+			deleted, err := p.DeleteRecords(context.Background(), testZone, []libdns.Record{tt.record})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deleted) != 0 || f.count("dnsDeleteRecord") != 0 {
+				t.Errorf("deleted %v with %d requests, want the NS record left alone", deleted, f.count("dnsDeleteRecord"))
+			}
+
+			record := tt.record
+			record.Value = "ns9.example.net"
+			if _, err := p.SetRecords(context.Background(), testZone, []libdns.Record{record}); err != nil {
+				t.Fatal(err)
+			}
+			if n := f.count("dnsUpdateRecord") + f.count("dnsAddRecord"); n != 0 {
+				t.Errorf("SetRecords sent %d writes, want the NS record left alone", n)
+			}
+		})
+	}
+}
+
+func TestEmptyBatchesMakeNoRequests(t *testing.T) {
+	methods := map[string]func(*Provider, []libdns.Record) ([]libdns.Record, error){
+		"AppendRecords": func(p *Provider, records []libdns.Record) ([]libdns.Record, error) {
+			return p.AppendRecords(context.Background(), testZone, records)
+		},
+		"SetRecords": func(p *Provider, records []libdns.Record) ([]libdns.Record, error) {
+			return p.SetRecords(context.Background(), testZone, records)
+		},
+		"CreateRecords": func(p *Provider, records []libdns.Record) ([]libdns.Record, error) {
+			return p.CreateRecords(context.Background(), testZone, records)
+		},
+		"UpdateRecords": func(p *Provider, records []libdns.Record) ([]libdns.Record, error) {
+			return p.UpdateRecords(context.Background(), testZone, records)
+		},
+		"DeleteRecords": func(p *Provider, records []libdns.Record) ([]libdns.Record, error) {
+			return p.DeleteRecords(context.Background(), testZone, records)
+		},
+		"ApplyTemplate": func(p *Provider, records []libdns.Record) ([]libdns.Record, error) {
+			result, err := p.ApplyTemplate(context.Background(), testZone, records)
+			return append(result.Created, result.Updated...), err
+		},
+	}
+
+	for name, method := range methods {
+		for _, records := range [][]libdns.Record{nil, {}} {
+			f := newFakeNamesilo(t)
+			got, err := method(f.provider(), records)
+			if err != nil {
+				t.Errorf("%s(%#v): %v", name, records, err)
+			}
+			if len(got) != 0 {
+				t.Errorf("%s(%#v) returned %v", name, records, got)
+			}
+			if n := f.count(""); n != 0 {
+				t.Errorf("%s(%#v) made %d requests, want none", name, records, n)
+			}
+		}
+	}
+}
+
+func TestGetRecordsFiltered(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "TXT", "_acme-challenge", "token"),
+		nsRecord("3", "TXT", "@", "v=spf1 -all"),
+	)
+
+	records, err := f.provider().GetRecordsFiltered(context.Background(), testZone, "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Type != "TXT" || records[1].Type != "TXT" {
+		t.Errorf("GetRecordsFiltered = %v, want the two TXT records", records)
+	}
+
+	// dnsListRecords has no record type filter; its type parameter picks
+	// the response format.
+	for _, req := range f.received("dnsListRecords") {
+		if got := req.Params["type"]; len(got) != 1 || got[0] != "xml" {
+			t.Errorf("dnsListRecords sent with type %v, want only the response format", got)
+		}
+		for name := range req.Params {
+			if name != "domain" && name != "key" && name != "version" && name != "type" {
+				t.Errorf("dnsListRecords sent with parameter %s", name)
+			}
+		}
+	}
+}
+
+func TestPriorityOnlySentForMXAndSRV(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	logger := &captureLogger{}
+	p.Logger = logger
+	p.Debug = true
+
+	created, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "TXT", Name: "note", Value: "hello", Priority: 10},
+		{Type: "MX", Name: "@", Value: "mail.example.com", Priority: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 {
+		t.Fatalf("created %d records, want 2", len(created))
+	}
+
+	adds := f.received("dnsAddRecord")
+	if _, ok := adds[0].Params["rrdistance"]; ok {
+		t.Errorf("TXT record sent with rrdistance %q", adds[0].Params.Get("rrdistance"))
+	}
+	if got := adds[1].Params.Get("rrdistance"); got != "0" {
+		t.Errorf("MX record sent with rrdistance %q, want 0", got)
+	}
+	if !logger.contains("ignoring priority 10 on TXT record note") {
+		t.Errorf("the ignored priority wasn't logged: %q", logger.lines)
+	}
+}
+
+func TestSetRecordsReportsNoChange(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	p := f.provider()
+	records := []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.2", TTL: time.Hour},
+		{Type: "TXT", Name: "note", Value: "hello", TTL: time.Hour},
+	}
+
+	changed, err := p.SetRecords(context.Background(), testZone, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("first SetRecords changed %v, want both records", changed)
+	}
+
+	writes := f.count("dnsAddRecord") + f.count("dnsUpdateRecord")
+	changed, err = p.SetRecords(context.Background(), testZone, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("second SetRecords changed %v, want nothing", changed)
+	}
+	if n := f.count("dnsAddRecord") + f.count("dnsUpdateRecord") - writes; n != 0 {
+		t.Errorf("second SetRecords sent %d writes, want none", n)
+	}
+}
+
+func TestDeleteRecordByID(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	p := f.provider()
+
+	if err := p.DeleteRecordByID(context.Background(), testZone, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.zone()) != 0 {
+		t.Errorf("zone still holds %v", f.zone())
+	}
+	if n := f.count("dnsListRecords"); n != 0 {
+		t.Errorf("listed the zone %d times, want the ID used directly", n)
+	}
+
+	err := p.DeleteRecordByID(context.Background(), testZone, "1")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("deleting a missing ID returned %v, want ErrRecordNotFound", err)
+	}
+
+	p.IgnoreMissing = true
+	if err := p.DeleteRecordByID(context.Background(), testZone, "1"); err != nil {
+		t.Errorf("deleting a missing ID with IgnoreMissing returned %v", err)
+	}
+}
+
+func TestMXWithZeroPriorityRoundTrips(t *testing.T) {
+	mx := nsRecord("1", "MX", "", "mail.example.com")
+	mx.Distance = 0
+	f := newFakeNamesilo(t, mx)
+	p := f.provider()
+	ctx := context.Background()
+
+	records, err := p.GetRecords(ctx, testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Priority != 0 {
+		t.Fatalf("GetRecords = %v, want the MX record with priority 0", records)
+	}
+
+	changed, err := p.SetRecords(ctx, testZone, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("setting the record as listed changed %v", changed)
+	}
+
+	record := records[0]
+	record.TTL = 2 * time.Hour
+	if _, err := p.SetRecords(ctx, testZone, []libdns.Record{record}); err != nil {
+		t.Fatal(err)
+	}
+	updates := f.received("dnsUpdateRecord")
+	if len(updates) != 1 {
+		t.Fatalf("sent %d updates, want 1", len(updates))
+	}
+	if got, ok := updates[0].Params["rrdistance"]; !ok || got[0] != "0" {
+		t.Errorf("update sent rrdistance %v, want 0", got)
+	}
+	if stored := f.zone()[0]; stored.Distance != 0 || stored.TTL != 7200 {
+		t.Errorf("stored record is %+v", stored)
+	}
+}
+
+func TestBatchTimeoutReturnsPartialResults(t *testing.T) {
+	f := newFakeNamesilo(t)
+	var adds int
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		adds++
+		slow := adds > 1
+		f.mu.Unlock()
+		if slow {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		f.serveDefault(w, r)
+	})
+	p := f.provider()
+	p.BatchTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	created, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "TXT", Name: "a", Value: "one"},
+		{Type: "TXT", Name: "b", Value: "two"},
+		{Type: "TXT", Name: "c", Value: "three"},
+	})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("AppendRecords took %v despite a batch timeout of %v", elapsed, p.BatchTimeout)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AppendRecords returned %v, want the batch deadline", err)
+	}
+	if len(created) != 1 || created[0].Name != "a" {
+		t.Errorf("AppendRecords returned %v, want the record created before the timeout", created)
+	}
+}
+
+func TestZoneWithTrailingDot(t *testing.T) {
+	for _, zone := range []string{"example.com", "example.com.", "Example.COM."} {
+		t.Run(zone, func(t *testing.T) {
+			f := newFakeNamesilo(t,
+				nsRecord("1", "A", "www", "192.0.2.1"),
+				nsRecord("2", "TXT", "", "v=spf1 -all"),
+			)
+			p := f.provider()
+			ctx := context.Background()
+
+			changed, err := p.SetRecords(ctx, zone, []libdns.Record{
+				{Type: "A", Name: "www", Value: "192.0.2.2"},
+				{Type: "TXT", Name: "@", Value: "v=spf1 -all", TTL: 2 * time.Hour},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changed) != 2 || f.count("dnsUpdateRecord") != 2 || f.count("dnsAddRecord") != 0 {
+				t.Errorf("changed %v with %d updates and %d adds, want both records updated in place",
+					changed, f.count("dnsUpdateRecord"), f.count("dnsAddRecord"))
+			}
+			for _, req := range f.received("") {
+				if got := req.Params.Get("domain"); got != testZone {
+					t.Errorf("%s sent for domain %q", req.Operation, got)
+				}
+			}
+
+			deleted, err := p.DeleteRecords(ctx, zone, []libdns.Record{{Type: "A", Name: "www.example.com.", Value: "192.0.2.2"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deleted) != 1 {
+				t.Errorf("deleted %v, want the A record", deleted)
+			}
+		})
+	}
+}
+
+func TestIgnoreMissingOnlyIgnoresMissingRecords(t *testing.T) {
+	f := newFakeNamesilo(t)
+	p := f.provider()
+	p.IgnoreMissing = true
+
+	if err := p.DeleteRecordByID(context.Background(), testZone, "404"); err != nil {
+		t.Errorf("deleting a missing ID returned %v", err)
+	}
+
+	p.APIToken = "wrong"
+	err := p.DeleteRecordByID(context.Background(), testZone, "404")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != codeInvalidAPIKey {
+		t.Errorf("deleting with an invalid key returned %v, want the API error", err)
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("invalid key reported as a missing record: %v", err)
+	}
+}
+
+func TestGetRecordsByType(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "A", "@", "192.0.2.2"),
+		nsRecord("3", "TXT", "_acme-challenge", "token"),
+		nsRecord("4", "mx", "@", "mail.example.com"),
+	)
+
+	grouped, err := f.provider().GetRecordsByType(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grouped) != 3 || len(grouped["A"]) != 2 || len(grouped["TXT"]) != 1 || len(grouped["MX"]) != 1 {
+		t.Errorf("GetRecordsByType = %v, want 2 A, 1 TXT and 1 MX record", grouped)
+	}
+	for recordType, records := range grouped {
+		for _, record := range records {
+			if !strings.EqualFold(record.Type, recordType) {
+				t.Errorf("%s record grouped under %s", record.Type, recordType)
+			}
+		}
+	}
+	if n := f.count("dnsListRecords"); n != 1 {
+		t.Errorf("listed the zone %d times, want once", n)
+	}
+}
+
+func TestGetRecordsSkipsRecordsWithoutID(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("", "NS", "", "ns1.dnsowl.com"),
+		nsRecord("1", "A", "www", "192.0.2.1"),
+	)
+	p := f.provider()
+
+	records, err := p.GetRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].ID != "1" {
+		t.Errorf("GetRecords = %v, want only the record with an ID", records)
+	}
+
+	// Reconciliation must leave the record alone rather than try to
+	// delete it by an empty ID.
+	if _, err := p.SyncZone(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range f.received("dnsDeleteRecord") {
+		t.Errorf("SyncZone deleted rrid %q", req.Params.Get("rrid"))
+	}
+}
+
+func TestMixedCaseNamesMatch(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "TXT", "_acme-challenge", "CaseSensitiveToken"),
+	)
+	p := f.provider()
+	ctx := context.Background()
+
+	if _, err := p.SetRecords(ctx, testZone, []libdns.Record{{Type: "A", Name: "WWW", Value: "192.0.2.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if f.count("dnsAddRecord") != 0 || f.count("dnsUpdateRecord") != 1 {
+		t.Errorf("sent %d adds and %d updates, want WWW to update www in place", f.count("dnsAddRecord"), f.count("dnsUpdateRecord"))
+	}
+	if got := f.received("dnsUpdateRecord")[0].Params.Get("rrhost"); got != "www" {
+		t.Errorf("update sent rrhost %q, want www", got)
+	}
+
+	// TXT values are case-sensitive: a token differing only in case is
+	// another record.
+	deleted, err := p.DeleteRecords(ctx, testZone, []libdns.Record{{Type: "TXT", Name: "_ACME-Challenge.Example.COM.", Value: "casesensitivetoken"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("deleted %v for a value differing in case", deleted)
+	}
+	deleted, err = p.DeleteRecords(ctx, testZone, []libdns.Record{{Type: "TXT", Name: "_ACME-Challenge.Example.COM.", Value: "CaseSensitiveToken"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("deleted %v, want the TXT record despite the name's case", deleted)
+	}
+}
+
+func TestRecordMatches(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "TXT", "_acme-challenge", "token-1"))
+	p := f.provider()
+
+	tests := []struct {
+		record libdns.Record
+		want   bool
+	}{
+		{libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token-1"}, true},
+		{libdns.Record{Type: "TXT", Name: "_acme-challenge.example.com.", Value: "token-1"}, true},
+		{libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "token-2"}, false},
+		{libdns.Record{Type: "TXT", Name: "other", Value: "token-1"}, false},
+		{libdns.Record{Type: "CNAME", Name: "_acme-challenge", Value: "token-1"}, false},
+	}
+	for _, tt := range tests {
+		got, err := p.RecordMatches(context.Background(), testZone, tt.record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("RecordMatches(%+v) = %v, want %v", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestSetRecordsStaleID(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	p := f.provider()
+
+	_, err := p.SetRecords(context.Background(), testZone, []libdns.Record{{ID: "99", Type: "A", Name: "www", Value: "192.0.2.2"}})
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("stale ID returned %v, want ErrRecordNotFound", err)
+	}
+	if n := f.count("dnsUpdateRecord") + f.count("dnsAddRecord"); n != 0 {
+		t.Errorf("sent %d writes for a stale ID", n)
+	}
+
+	p.AppendStaleIDs = true
+	changed, err := p.SetRecords(context.Background(), testZone, []libdns.Record{{ID: "99", Type: "A", Name: "www", Value: "192.0.2.2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].ID != "1" || f.count("dnsUpdateRecord") != 1 {
+		t.Errorf("with AppendStaleIDs changed %v, want record 1 updated by name", changed)
+	}
+}
+
+func TestSetRecordsIDMatchedEarlierInBatch(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+
+	// The first record takes record 1 by name; the second names it by ID,
+	// which is still in the zone.
+	_, err := f.provider().SetRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.2"},
+		{ID: "1", Type: "A", Name: "www", Value: "192.0.2.3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone := f.zone(); len(zone) != 1 || zone[0].Value != "192.0.2.3" {
+		t.Errorf("zone holds %v, want record 1 with the last value", zone)
+	}
+}
+
+func TestImplementsLibdnsInterfaces(t *testing.T) {
+	var provider interface{} = new(Provider)
+	if _, ok := provider.(libdns.RecordGetter); !ok {
+		t.Error("Provider is not a libdns.RecordGetter")
+	}
+	if _, ok := provider.(libdns.RecordAppender); !ok {
+		t.Error("Provider is not a libdns.RecordAppender")
+	}
+	if _, ok := provider.(libdns.RecordSetter); !ok {
+		t.Error("Provider is not a libdns.RecordSetter")
+	}
+	if _, ok := provider.(libdns.RecordDeleter); !ok {
+		t.Error("Provider is not a libdns.RecordDeleter")
+	}
+}
+
+func TestReturnServerState(t *testing.T) {
+	for _, returnServerState := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ReturnServerState=%v", returnServerState), func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			// The fake stores the value lowercased, as a server normalizing
+			// records might.
+			f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+				r.Form.Set("rrvalue", strings.ToLower(r.Form.Get("rrvalue")))
+				f.serveDefault(w, r)
+			})
+			p := f.provider()
+			p.ReturnServerState = returnServerState
+
+			appended, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{
+				{Type: "CNAME", Name: "www", Value: "Target.Example.org.", TTL: time.Hour},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(appended) != 1 || appended[0].ID == "" {
+				t.Fatalf("AppendRecords = %+v, want the record with its ID", appended)
+			}
+
+			want, listings := "Target.Example.org.", 0
+			if returnServerState {
+				want, listings = "target.example.org.", 1
+			}
+			if appended[0].Value != want || appended[0].TTL != time.Hour {
+				t.Errorf("AppendRecords = %+v, want value %q and TTL 1h", appended[0], want)
+			}
+			if n := f.count("dnsListRecords"); n != listings {
+				t.Errorf("sent %d listings, want %d", n, listings)
+			}
+		})
+	}
+}
+
+func TestDeleteRecordsFailsWhenListingFails(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "TXT", "_acme-challenge", "token"))
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsListRecords", codeInvalidAPIKey, "Invalid API Key", "")
+	})
+
+	_, err := f.provider().DeleteRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "TXT", Name: "_acme-challenge", Value: "token"},
+	})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != codeInvalidAPIKey {
+		t.Errorf("DeleteRecords returned %v, want the listing's code %d", err, codeInvalidAPIKey)
+	}
+	if n := f.count("dnsDeleteRecord"); n != 0 {
+		t.Errorf("sent %d deletes after the listing failed", n)
+	}
+}
+
+func TestGetRecordsSorted(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "TXT", "www", "b"),
+		nsRecord("2", "A", "www", "192.0.2.2"),
+		nsRecord("3", "TXT", "", "v=spf1 -all"),
+		nsRecord("4", "TXT", "www", "a"),
+		nsRecord("5", "A", "mail", "192.0.2.1"),
+		nsRecord("6", "TXT", "www", "a"),
+	)
+
+	records, err := f.provider().GetRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	// Equal records keep namesilo's order.
+	if got, want := strings.Join(ids, " "), "3 5 2 4 6 1"; got != want {
+		t.Errorf("GetRecords returned IDs %s, want %s", got, want)
+	}
+}
+
+func TestUpdateWithEmptyValueRefused(t *testing.T) {
+	// A TTL alone would otherwise be sent with an empty rrvalue.
+	for _, value := range []string{"", "  "} {
+		f := newFakeNamesilo(t, nsRecord("1", "TXT", "_acme-challenge", "token"))
+
+		_, err := f.provider().SetRecords(context.Background(), testZone, []libdns.Record{
+			{ID: "1", Type: "TXT", Name: "_acme-challenge", Value: value, TTL: time.Hour},
+		})
+		if err == nil {
+			t.Errorf("SetRecords with value %q succeeded", value)
+		}
+		if n := f.count("dnsUpdateRecord"); n != 0 {
+			t.Errorf("sent %d updates with value %q", n, value)
+		}
+		if zone := f.zone(); len(zone) != 1 || zone[0].Value != "token" {
+			t.Errorf("zone holds %+v, want the record unchanged", zone)
+		}
+	}
+}
+
+func TestApexForms(t *testing.T) {
+	for _, name := range []string{"", "@", " @ ", "example.com", "EXAMPLE.com."} {
+		f := newFakeNamesilo(t, nsRecord("1", "TXT", "", "v=spf1 -all"))
+		p := f.provider()
+
+		if _, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: name, Value: "192.0.2.1"}}); err != nil {
+			t.Errorf("appending at %q: %v", name, err)
+			continue
+		}
+		if got := f.received("dnsAddRecord")[0].Params.Get("rrhost"); got != "" {
+			t.Errorf("appending at %q sent rrhost %q, want the apex", name, got)
+		}
+
+		_, err := p.SetRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: name, Value: "v=spf1 mx -all"}})
+		if err != nil {
+			t.Errorf("setting at %q: %v", name, err)
+			continue
+		}
+		if f.count("dnsAddRecord") != 1 || f.count("dnsUpdateRecord") != 1 {
+			t.Errorf("setting at %q sent %d adds and %d updates, want the apex record updated",
+				name, f.count("dnsAddRecord")-1, f.count("dnsUpdateRecord"))
+		}
+	}
+}
+
+func TestMatchStrategy(t *testing.T) {
+	tests := []struct {
+		strategy MatchStrategy
+		value    string
+		updates  int
+		adds     int
+	}{
+		{MatchTypeName, "b", 1, 0},
+		{MatchTypeName, "a", 1, 0},
+		{MatchID, "b", 0, 1},
+		{MatchID, "a", 0, 1},
+		{MatchTypeNameValue, "b", 0, 1},
+		{MatchTypeNameValue, "a", 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy.String()+" "+tt.value, func(t *testing.T) {
+			f := newFakeNamesilo(t, nsRecord("1", "TXT", "note", "a"))
+			p := f.provider()
+			p.MatchStrategy = tt.strategy
+
+			// The new TTL makes an update of the matched record necessary.
+			_, err := p.SetRecords(context.Background(), testZone, []libdns.Record{
+				{Type: "TXT", Name: "note", Value: tt.value, TTL: 2 * time.Hour},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.count("dnsUpdateRecord") != tt.updates || f.count("dnsAddRecord") != tt.adds {
+				t.Errorf("sent %d updates and %d adds, want %d and %d",
+					f.count("dnsUpdateRecord"), f.count("dnsAddRecord"), tt.updates, tt.adds)
+			}
+		})
+	}
+}
+
+func TestGetRawRecords(t *testing.T) {
+	zone := []NamesiloRecord{
+		nsRecord("2", "TXT", "www", "v=spf1 -all"),
+		nsRecord("1", "NS", "", "ns1.dnsowl.com"),
+		{RecordID: "3", Type: "MX", Host: "example.com", Value: "mail.example.com", TTL: 7207, Distance: 10},
+	}
+	f := newFakeNamesilo(t, zone...)
+	p := f.provider()
+	p.ExcludeSystemRecords = true
+
+	records, err := p.GetRawRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(zone) {
+		t.Fatalf("GetRawRecords returned %d records, want all %d", len(records), len(zone))
+	}
+	for i := range zone {
+		if records[i] != zone[i] {
+			t.Errorf("record %d is %+v, want %+v", i, records[i], zone[i])
+		}
+	}
+}
+
+func TestSetRecordsCollapsesDuplicates(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "", "192.0.2.1"))
+
+	result, err := f.provider().SetRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "@", Value: "192.0.2.2"},
+		{Type: "A", Name: "example.com.", Value: "192.0.2.2"},
+		{Type: "TXT", Name: "www", Value: "note"},
+		{Type: "txt", Name: "WWW.example.com", Value: "note"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Errorf("SetRecords = %v, want each record once", result)
+	}
+	if f.count("dnsUpdateRecord") != 1 || f.count("dnsAddRecord") != 1 {
+		t.Errorf("sent %d updates and %d adds, want one of each", f.count("dnsUpdateRecord"), f.count("dnsAddRecord"))
+	}
+	if n := len(f.zone()); n != 2 {
+		t.Errorf("zone holds %d records, want 2", n)
+	}
+}
+
+func TestSetRecordsIDAlone(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "TXT", "_acme-challenge", "token"))
+
+	changed, err := f.provider().SetRecords(context.Background(), testZone, []libdns.Record{
+		{ID: "1", Type: "TXT", Name: "_acme-challenge"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("SetRecords changed %v, want nothing", changed)
+	}
+	if n := f.count("dnsUpdateRecord"); n != 0 {
+		t.Errorf("sent %d updates without rrvalue or rrttl", n)
+	}
+	if zone := f.zone(); len(zone) != 1 || zone[0].Value != "token" || zone[0].TTL != 3600 {
+		t.Errorf("zone holds %+v, want the record unchanged", zone)
+	}
+}
+
+func TestMissingToken(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+	p := f.provider()
+	p.APIToken = ""
+	ctx := context.Background()
+	records := []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.2"}}
+
+	calls := map[string]func() error{
+		"GetRecords": func() error {
+			_, err := p.GetRecords(ctx, testZone)
+			return err
+		},
+		"AppendRecords": func() error {
+			_, err := p.AppendRecords(ctx, testZone, records)
+			return err
+		},
+		"SetRecords": func() error {
+			_, err := p.SetRecords(ctx, testZone, records)
+			return err
+		},
+		"DeleteRecords": func() error {
+			_, err := p.DeleteRecords(ctx, testZone, records)
+			return err
+		},
+		"SyncZone": func() error {
+			_, err := p.SyncZone(ctx, testZone, records)
+			return err
+		},
+		"GetNameservers": func() error {
+			_, err := p.GetNameservers(ctx, testZone)
+			return err
+		},
+		"GetAccountBalance": func() error {
+			_, err := p.GetAccountBalance(ctx)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrMissingToken) {
+			t.Errorf("%s returned %v, want ErrMissingToken", name, err)
+		}
+	}
+	if n := f.count(""); n != 0 {
+		t.Errorf("sent %d requests without a token", n)
+	}
+}
+
+func TestLenientTTLAndDistance(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsListRecords", codeSuccess, "success",
+			`<resource_record><record_id>1</record_id><type>MX</type><host>example.com</host><value>mail.example.com</value><ttl> 3600 </ttl><distance> 10 </distance></resource_record>`+
+				`<resource_record><record_id>2</record_id><type>MX</type><host>example.com</host><value>backup.example.com</value><ttl>auto</ttl><distance>n/a</distance></resource_record>`+
+				`<resource_record><record_id>3</record_id><type>MX</type><host>example.com</host><value>spare.example.com</value><ttl>3600</ttl><distance>99999999999999999999</distance></resource_record>`)
+	})
+	logger := &captureLogger{}
+	p := f.provider()
+	p.Logger = logger
+	p.Debug = true
+
+	records, err := p.GetRecords(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]libdns.Record{
+		"1": {TTL: time.Hour, Priority: 10},
+		"2": {TTL: DefaultTTL, Priority: 0},
+		"3": {TTL: time.Hour, Priority: 0},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("GetRecords returned %d records, want %d", len(records), len(want))
+	}
+	for _, record := range records {
+		if w := want[record.ID]; record.TTL != w.TTL || record.Priority != w.Priority {
+			t.Errorf("record %s has TTL %v and priority %d, want %v and %d", record.ID, record.TTL, record.Priority, w.TTL, w.Priority)
+		}
+	}
+	if !logger.contains(`invalid distance "n/a"`) {
+		t.Errorf("the invalid distance wasn't logged: %v", logger.lines)
+	}
+}
+
+func TestDeleteRecordsNormalizes(t *testing.T) {
+	f := newFakeNamesilo(t,
+		NamesiloRecord{RecordID: "1", Type: "MX", Host: "example.com", Value: "mail.example.com", TTL: 3600, Distance: 10},
+		nsRecord("2", "TXT", "_acme-challenge", "token"),
+		nsRecord("3", "A", "www", "192.0.2.1"),
+		nsRecord("4", "A", "keep", "192.0.2.1"),
+	)
+	p := f.provider()
+
+	deleted, err := p.DeleteRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "MX", Name: "@", Value: "mail.example.com.", Priority: 10},
+		{Type: "txt", Name: "_acme-challenge.example.com.", Value: "token", TTL: 5 * time.Hour},
+		// An ID alone is deleted as given.
+		{ID: "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 3 {
+		t.Errorf("DeleteRecords deleted %v, want 3 records", deleted)
+	}
+	if zone := f.zone(); len(zone) != 1 || zone[0].RecordID != "4" {
+		t.Errorf("zone holds %+v, want record 4 only", zone)
+	}
+
+	_, err = p.DeleteRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "keep.other.com.", Value: "192.0.2.1"}})
+	if err == nil {
+		t.Error("DeleteRecords accepted a name outside the zone")
+	}
+	if n := f.count("dnsDeleteRecord"); n != 3 {
+		t.Errorf("sent %d deletes, want 3", n)
+	}
+}
+
+func TestAppendRecordsSkipsDuplicates(t *testing.T) {
+	f := newFakeNamesilo(t)
+
+	appended, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.1"},
+		{Type: "a", Name: "WWW.example.com.", Value: "192.0.2.1"},
+		{ID: "7", Type: "A", Name: "www", Value: "192.0.2.1"},
+		{Type: "A", Name: "www", Value: "192.0.2.2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appended) != 2 || f.count("dnsAddRecord") != 2 {
+		t.Errorf("appended %v with %d adds, want each value once", appended, f.count("dnsAddRecord"))
+	}
+}
+
+func TestCreateAndUpdateRecords(t *testing.T) {
+	existing := libdns.Record{Type: "A", Name: "www", Value: "192.0.2.2"}
+	missing := libdns.Record{Type: "A", Name: "new", Value: "192.0.2.2"}
+	tests := []struct {
+		name    string
+		set     func(*Provider, context.Context, string, []libdns.Record) ([]libdns.Record, error)
+		record  libdns.Record
+		wantErr error
+		adds    int
+		updates int
+	}{
+		{"create new", (*Provider).CreateRecords, missing, nil, 1, 0},
+		{"create existing", (*Provider).CreateRecords, existing, ErrRecordExists, 0, 0},
+		{"create with ID", (*Provider).CreateRecords, libdns.Record{ID: "1", Type: "A", Name: "www", Value: "192.0.2.2"}, ErrRecordExists, 0, 0},
+		{"update existing", (*Provider).UpdateRecords, existing, nil, 0, 1},
+		{"update missing", (*Provider).UpdateRecords, missing, ErrRecordNotFound, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t, nsRecord("1", "A", "www", "192.0.2.1"))
+
+			_, err := tt.set(f.provider(), context.Background(), testZone, []libdns.Record{tt.record})
+			if tt.wantErr == nil && err != nil || !errors.Is(err, tt.wantErr) {
+				t.Errorf("returned %v, want %v", err, tt.wantErr)
+			}
+			if f.count("dnsAddRecord") != tt.adds || f.count("dnsUpdateRecord") != tt.updates {
+				t.Errorf("sent %d adds and %d updates, want %d and %d",
+					f.count("dnsAddRecord"), f.count("dnsUpdateRecord"), tt.adds, tt.updates)
+			}
+		})
+	}
+}
+
+func TestConcurrentAppendsSkipExisting(t *testing.T) {
+	f := newFakeNamesilo(t)
+	record := libdns.Record{Type: "A", Name: "@", Value: "192.0.2.1"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each caller has its own Provider, as separate certificate
+			// renewals might.
+			p := f.provider()
+			p.SkipExisting = true
+			if _, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{record}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := f.count("dnsAddRecord"); n != 1 {
+		t.Errorf("sent %d adds, want the apex record created once", n)
+	}
+	if n := len(f.zone()); n != 1 {
+		t.Errorf("zone holds %d records, want 1", n)
+	}
+}
+
+func TestBatchLogsShortenValues(t *testing.T) {
+	const token = "Vq3nX9kLmP2rT7wYzA4bC6dE8fG0hJ1k"
+	f := newFakeNamesilo(t)
+	logger := &captureLogger{}
+	p := f.provider()
+	p.Logger = logger
+	records := []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: token}}
+	ctx := context.Background()
+
+	if _, err := p.AppendRecords(ctx, testZone, records); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SetRecords(ctx, testZone, records); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.DeleteRecords(ctx, testZone, records); err != nil {
+		t.Fatal(err)
+	}
+	if logger.contains(token) {
+		t.Errorf("the full value was logged: %v", logger.lines)
+	}
+	if !logger.contains(shortenValue(token)) {
+		t.Errorf("the shortened value wasn't logged: %v", logger.lines)
+	}
+}
@@ -0,0 +1,174 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bounds of the backoff between retries of a failed request.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff returns how long to wait before retry number attempt, counting
+// from zero. The delay doubles with each attempt up to max, and is then
+// randomized to between half and all of that, so that requests for many
+// records failing at once don't all come back at the same moment.
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := max
+	if attempt < 32 && base<<attempt < max {
+		delay = base << attempt
+	}
+
+	jitterMu.Lock()
+	jitter := time.Duration(jitterRand.Int63n(int64(delay/2) + 1))
+	jitterMu.Unlock()
+
+	return delay/2 + jitter
+}
+
+// Clock is a source of time for a Provider's waits.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current time once d has
+	// elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the default Clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (p *Provider) clock() Clock {
+	if p.Clock != nil {
+		return p.Clock
+	}
+	return systemClock{}
+}
+
+// wait sleeps for d on the provider's clock, returning early with the
+// context's error if it is done first. All waits between attempts go
+// through it, so that cancelling an operation never leaves it sleeping out
+// a backoff.
+func (p *Provider) wait(ctx context.Context, d time.Duration) error {
+	// select picks at random among ready cases, so check first that the
+	// context isn't done already.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.clock().After(d):
+		return nil
+	}
+}
+
+// retryReason decides whether a request that returned err, and decoded
+// its reply into v, is worth retrying, and if so returns why. It returns
+// nil for requests that succeeded or failed for good.
+//
+// A namesilo reply code, whether in a successful response or an error
+// page, decides on its own: 115 (registry not responding) and 201
+// (internal error) are retried, while every other code, such as the
+// authentication failures 109 to 113, is permanent whatever the HTTP
+// status. A failed reply whose detail contains one of RetryDetails is
+// retried too. Without a reply code, HTTP 429 and 5xx responses, empty
+// responses and timed out connections are retried.
+func (p *Provider) retryReason(err error, v interface{}) error {
+	if err == nil {
+		reply, ok := v.(interface {
+			replyCode() int
+			replyDetail() string
+		})
+		if !ok || reply.replyCode() == codeSuccess {
+			return nil
+		}
+		if isRetryableCode(reply.replyCode()) || p.isRetryableDetail(reply.replyDetail()) {
+			return fmt.Errorf("reply code %d: %s", reply.replyCode(), reply.replyDetail())
+		}
+		return nil
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.ReplyCode != 0 {
+			if isRetryableCode(httpErr.ReplyCode) || p.isRetryableDetail(httpErr.ReplyDetail) {
+				return err
+			}
+			return nil
+		}
+		if httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500 {
+			return err
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, ErrEmptyResponse) {
+		return err
+	}
+	return nil
+}
+
+// isRetryableCode reports whether a reply code marks a transient failure
+// on namesilo's side.
+func isRetryableCode(code int) bool {
+	return code == codeRegistryNotReady || code == codeInternalError
+}
+
+// defaultRetryDetails are used when Provider.RetryDetails is nil.
+var defaultRetryDetails = []string{
+	"try again",
+	"temporarily unavailable",
+	"timed out",
+}
+
+// isRetryableDetail reports whether a reply detail message says that the
+// request may succeed later.
+func (p *Provider) isRetryableDetail(detail string) bool {
+	details := p.RetryDetails
+	if details == nil {
+		details = defaultRetryDetails
+	}
+	detail = strings.ToLower(detail)
+	for _, d := range details {
+		if d != "" && strings.Contains(detail, strings.ToLower(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resetReply zeroes the reply struct v points to, or lets it reset
+// itself if it holds state that must outlive a retry.
+func resetReply(v interface{}) {
+	if r, ok := v.(interface{ reset() }); ok {
+		r.reset()
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+}
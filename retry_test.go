@@ -0,0 +1,302 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+func TestRetriesAreSpreadOut(t *testing.T) {
+	f := newFakeNamesilo(t)
+	failed := make(map[string]bool)
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		host := r.FormValue("rrhost")
+		f.mu.Lock()
+		first := !failed[host]
+		failed[host] = true
+		f.mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		f.serveDefault(w, r)
+	})
+	p := f.provider()
+	p.MaxRetries = 3
+	clock := &instantClock{}
+	p.Clock = clock
+
+	var records []libdns.Record
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		records = append(records, libdns.Record{Type: "TXT", Name: name, Value: "value"})
+	}
+	created, err := p.AppendRecords(context.Background(), testZone, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != len(records) || len(f.zone()) != len(records) {
+		t.Fatalf("created %d records, zone holds %d; want %d", len(created), len(f.zone()), len(records))
+	}
+
+	waits := clock.waited()
+	if len(waits) != len(records) {
+		t.Fatalf("waited %d times, want once per record", len(waits))
+	}
+	distinct := make(map[int64]bool)
+	for _, wait := range waits {
+		if wait < retryBaseDelay/2 || wait > retryBaseDelay {
+			t.Errorf("waited %v, want between %v and %v", wait, retryBaseDelay/2, retryBaseDelay)
+		}
+		distinct[int64(wait)] = true
+	}
+	if len(distinct) < 2 {
+		t.Errorf("every record waited %v before retrying, want the retries spread out", waits[0])
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		if delay := backoff(attempt, retryBaseDelay, retryMaxDelay); delay > retryMaxDelay || delay < retryBaseDelay/2 {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempt, delay, retryBaseDelay/2, retryMaxDelay)
+		}
+	}
+}
+
+func TestTransportErrorsRedactToken(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	logger := &captureLogger{}
+	p := f.provider()
+	p.APIToken = "secret/token+key"
+	p.Logger = logger
+	p.MaxRetries = 1
+	p.Clock = &instantClock{}
+	// A client timeout fails the request with a *url.Error quoting the
+	// URL, and is retried, so the error is logged too.
+	p.client = &http.Client{Timeout: 50 * time.Millisecond}
+
+	_, err := p.GetRecords(context.Background(), testZone)
+	if err == nil {
+		t.Fatal("GetRecords succeeded against a hanging server")
+	}
+	if !logger.contains("retrying in") {
+		t.Fatalf("the timeout wasn't retried: %q", logger.lines)
+	}
+	for _, leak := range []string{"secret/token+key", "secret%2Ftoken%2Bkey"} {
+		if strings.Contains(err.Error(), leak) {
+			t.Errorf("error %q leaks the API token", err)
+		}
+		if logger.contains(leak) {
+			t.Errorf("log %q leaks the API token", logger.lines)
+		}
+	}
+	if !strings.Contains(err.Error(), "key=REDACTED") {
+		t.Errorf("error %q doesn't show the redacted key", err)
+	}
+}
+
+func TestRetriedAppendDoesNotDuplicate(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+		// The record is created, but the reply is lost.
+		f.serveDefault(httptest.NewRecorder(), r)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	p := f.provider()
+	p.MaxRetries = 2
+	p.Clock = &instantClock{}
+
+	created, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("dnsAddRecord"); n != 1 {
+		t.Errorf("sent %d adds, want the retry to find the record instead", n)
+	}
+	zone := f.zone()
+	if len(zone) != 1 {
+		t.Fatalf("zone holds %d records, want 1", len(zone))
+	}
+	if len(created) != 1 || created[0].ID != zone[0].RecordID {
+		t.Errorf("AppendRecords returned %v, want the record with ID %s", created, zone[0].RecordID)
+	}
+}
+
+func TestRetryClassification(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		code     int // zero for an error page without a namesilo reply
+		attempts int
+	}{
+		{"registry not ready", http.StatusOK, codeRegistryNotReady, 3},
+		{"internal error", http.StatusOK, codeInternalError, 3},
+		{"invalid key", http.StatusOK, codeInvalidAPIKey, 1},
+		{"invalid key on an error page", http.StatusServiceUnavailable, codeInvalidAPIKey, 1},
+		{"internal error on an error page", http.StatusInternalServerError, codeInternalError, 3},
+		{"unavailable", http.StatusServiceUnavailable, 0, 3},
+		{"too many requests", http.StatusTooManyRequests, 0, 3},
+		{"bad request", http.StatusBadRequest, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+				if tt.code == 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(tt.status)
+				writeReply(w, "dnsListRecords", tt.code, "failed", "")
+			})
+			p := f.provider()
+			p.MaxRetries = 2
+			p.Clock = &instantClock{}
+
+			if _, err := p.GetRecords(context.Background(), testZone); err == nil {
+				t.Error("GetRecords succeeded")
+			}
+			if n := f.count("dnsListRecords"); n != tt.attempts {
+				t.Errorf("sent %d requests, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryDetails(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		details  []string
+		detail   string
+		attempts int
+	}{
+		{"default", http.StatusOK, nil, "Please Try Again later", 2},
+		{"default, permanent", http.StatusOK, nil, "Invalid value", 1},
+		{"configured", http.StatusOK, []string{"busy"}, "Server BUSY", 2},
+		{"configured replaces the default", http.StatusOK, []string{"busy"}, "Please try again later", 1},
+		{"none", http.StatusOK, []string{}, "Please try again later", 1},
+		{"on an error page", http.StatusServiceUnavailable, []string{"busy"}, "Server busy", 2},
+		{"on an error page, permanent", http.StatusServiceUnavailable, []string{"busy"}, "Invalid value", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			f.handle("dnsAddRecord", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(tt.status)
+				writeReply(w, "dnsAddRecord", codeDNSModification, tt.detail, "")
+			})
+			p := f.provider()
+			p.MaxRetries = 1
+			p.Clock = &instantClock{}
+			p.RetryDetails = tt.details
+
+			if _, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1"}}); err == nil {
+				t.Error("AppendRecords succeeded")
+			}
+			if n := f.count("dnsAddRecord"); n != tt.attempts {
+				t.Errorf("sent %d requests, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRetryWaitEndsWithContext(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	p := f.provider()
+	p.MaxRetries = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := p.GetRecords(ctx, testZone)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetRecords returned %v, want context.Canceled", err)
+	}
+	// The first backoff is at least 250ms.
+	if elapsed := time.Since(start); elapsed >= 250*time.Millisecond {
+		t.Errorf("GetRecords returned after %v, want it to stop waiting when cancelled", elapsed)
+	}
+	if n := f.count("dnsListRecords"); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+
+	// A wait that could end at once still reports a context that is
+	// already done.
+	p.Clock = &instantClock{}
+	for i := 0; i < 20; i++ {
+		if err := p.wait(ctx, time.Second); !errors.Is(err, context.Canceled) {
+			t.Fatalf("wait on a cancelled context returned %v", err)
+		}
+	}
+}
+
+func TestClockDrivesWaits(t *testing.T) {
+	t.Run("retries", func(t *testing.T) {
+		f := newFakeNamesilo(t)
+		f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		p := f.provider()
+		p.MaxRetries = 4
+		clock := &instantClock{}
+		p.Clock = clock
+
+		if _, err := p.GetRecords(context.Background(), testZone); err == nil {
+			t.Error("GetRecords succeeded")
+		}
+		waits := clock.waited()
+		if len(waits) != p.MaxRetries {
+			t.Fatalf("waited %d times, want %d", len(waits), p.MaxRetries)
+		}
+		for i, wait := range waits {
+			if max := retryBaseDelay << i; wait < max/2 || wait > max {
+				t.Errorf("wait %d was %v, want between %v and %v", i, wait, max/2, max)
+			}
+		}
+	})
+
+	t.Run("polls", func(t *testing.T) {
+		f := newFakeNamesilo(t, nsRecord("1", "TXT", "_acme-challenge", "token"))
+		f.hide("1", 1000)
+		p := f.provider()
+		p.PropagationPollTimeout = 20 * time.Second
+		clock := &instantClock{}
+		p.Clock = clock
+
+		deleted, err := p.DeleteRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: "_acme-challenge", Value: "token"}})
+		if err != nil || len(deleted) != 0 {
+			t.Fatalf("DeleteRecords = %v, %v; want nothing deleted after the poll timed out", deleted, err)
+		}
+
+		var total time.Duration
+		for _, wait := range clock.waited() {
+			if wait > 5*time.Second {
+				t.Errorf("waited %v between polls, want at most 5s", wait)
+			}
+			total += wait
+		}
+		if total != p.PropagationPollTimeout {
+			t.Errorf("polled for %v, want exactly the %v timeout", total, p.PropagationPollTimeout)
+		}
+		if n := f.count("dnsListRecords"); n != len(clock.waited())+1 {
+			t.Errorf("listed the zone %d times for %d waits", n, len(clock.waited()))
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package namesilo
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+
+	"github.com/libdns/libdns"
+)
+
+// ForEachRecord calls fn for each record in the zone as the listing is
+// decoded, without holding the whole zone in memory. Records are passed in
+// namesilo's order rather than sorted, and are otherwise the ones GetRecords
+// would return. Iteration stops at the first error from fn, which is
+// returned, or when ctx is done.
+//
+// A listing that fails after fn has seen some of its records is not
+// retried, as fn would see those records again.
+func (p *Provider) ForEachRecord(ctx context.Context, zone string, fn func(libdns.Record) error) error {
+	zone = getDomain(zone)
+	p.logf("ForEachRecord %s", zone)
+
+	reply := recordStream{
+		Records: recordSink{
+			ctx: ctx,
+			fn: func(record NamesiloRecord) error {
+				rec, ok := p.toLibdnsRecord(zone, record)
+				if !ok || p.ExcludeSystemRecords && isSystemRecord(zone, rec) {
+					return nil
+				}
+				return fn(rec)
+			},
+		},
+	}
+
+	sink := &reply.Records
+	_, err := p.retryCall(ctx, "dnsListRecords", url.Values{"domain": {zone}}, &reply, func() (bool, error) {
+		if sink.delivered > 0 {
+			return false, fmt.Errorf("dnsListRecords: listing of %s interrupted after %d records", zone, sink.delivered)
+		}
+		return false, sink.err
+	})
+	if sink.err != nil {
+		return sink.err
+	}
+	if err != nil {
+		return err
+	}
+
+	return reply.err(zone, "")
+}
+
+// recordStream is the dnsListRecords reply for ForEachRecord, handing each
+// record to its sink instead of collecting them.
+type recordStream struct {
+	apiReply
+	Records recordSink `xml:"reply>resource_record"`
+}
+
+// reset clears the reply for a retry, keeping the sink.
+func (r *recordStream) reset() {
+	r.apiReply = apiReply{}
+}
+
+// recordSink receives the records of a listing one at a time.
+type recordSink struct {
+	ctx context.Context
+	fn  func(NamesiloRecord) error
+
+	// delivered counts the records passed to fn.
+	delivered int
+
+	// err is the error that stopped the listing, from fn or ctx.
+	err error
+}
+
+// UnmarshalXML is called once for every resource_record element.
+func (s *recordSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var record NamesiloRecord
+	if err := d.DecodeElement(&record, &start); err != nil {
+		return err
+	}
+
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.fn(record); err != nil {
+		s.err = err
+		return err
+	}
+	s.delivered++
+	return nil
+}
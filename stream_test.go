@@ -0,0 +1,56 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/libdns/libdns"
+)
+
+func TestForEachRecord(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("3", "TXT", "www", "b"),
+		nsRecord("1", "NS", "", "ns1.dnsowl.com"),
+		nsRecord("", "A", "noid", "192.0.2.9"),
+		nsRecord("2", "A", "mail", "192.0.2.1"),
+		nsRecord("4", "TXT", "www", "a"),
+	)
+	p := f.provider()
+	p.ExcludeSystemRecords = true
+
+	var ids []string
+	err := p.ForEachRecord(context.Background(), testZone, func(record libdns.Record) error {
+		ids = append(ids, record.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// In namesilo's order, without the apex NS record or the record
+	// lacking an ID.
+	if got := strings.Join(ids, " "); got != "3 2 4" {
+		t.Errorf("ForEachRecord passed records %s, want 3 2 4", got)
+	}
+
+	stop := errors.New("stop")
+	ids = nil
+	err = p.ForEachRecord(context.Background(), testZone, func(record libdns.Record) error {
+		ids = append(ids, record.ID)
+		return stop
+	})
+	if err != stop || len(ids) != 1 {
+		t.Errorf("ForEachRecord returned %v after %d records, want the callback's error after one", err, len(ids))
+	}
+
+	f.handle("dnsListRecords", func(w http.ResponseWriter, r *http.Request) {
+		writeReply(w, "dnsListRecords", codeInvalidAPIKey, "Invalid API Key", "")
+	})
+	err = p.ForEachRecord(context.Background(), testZone, func(libdns.Record) error { return nil })
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != codeInvalidAPIKey {
+		t.Errorf("ForEachRecord returned %v, want the reply's error", err)
+	}
+}
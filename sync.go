@@ -0,0 +1,470 @@
+package namesilo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// SyncResult describes the changes made while reconciling a zone.
+type SyncResult struct {
+	Created []libdns.Record
+	Updated []libdns.Record
+	Deleted []libdns.Record
+
+	// Requests is the number of API requests the operation made, which
+	// count against namesilo's rate limits.
+	Requests int
+}
+
+// Changed reports whether reconciling made any change to the zone.
+func (r SyncResult) Changed() bool {
+	return len(r.Created) > 0 || len(r.Updated) > 0 || len(r.Deleted) > 0
+}
+
+// ApplyTemplate sets the template records in the zone, creating or updating
+// them like SetRecords. Template record names should be relative, so the
+// same template can be stamped onto any number of domains; a name fully
+// qualified in another zone fails like it would with SetRecords. Records in
+// the zone that aren't part of the template are left alone.
+func (p *Provider) ApplyTemplate(ctx context.Context, zone string, template []libdns.Record) (SyncResult, error) {
+	if len(template) == 0 {
+		return SyncResult{}, nil
+	}
+
+	zone = getDomain(zone)
+	p.logf("ApplyTemplate %s %v", zone, logRecords(template))
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	records := make([]libdns.Record, 0, len(template))
+	for _, record := range template {
+		// IDs in a template belong to whichever zone it was taken from.
+		record.ID = ""
+		records = append(records, record)
+	}
+
+	return p.setRecords(ctx, zone, records, setAny)
+}
+
+// SyncPlan lists the changes that would reconcile a zone with the desired
+// records.
+type SyncPlan struct {
+	Create []libdns.Record
+	Update []libdns.Record
+
+	// Delete holds the records in the zone that aren't among the desired
+	// ones, and would be removed.
+	Delete []libdns.Record
+}
+
+// PlanSync works out the changes SyncZone would make to the zone without
+// making them, so that they can be reviewed first. Only the zone's records
+// are fetched.
+func (p *Provider) PlanSync(ctx context.Context, zone string, desired []libdns.Record) (SyncPlan, error) {
+	zone = getDomain(zone)
+	p.logf("PlanSync %s %v", zone, logRecords(desired))
+
+	return p.planZone(ctx, zone, desired)
+}
+
+// SyncZone makes the zone hold exactly the desired records: missing records
+// are created, records whose value can be reused are updated in place and
+// all others are deleted. The zone's SOA and apex NS records are never
+// deleted. Use PlanSync to preview the changes.
+func (p *Provider) SyncZone(ctx context.Context, zone string, desired []libdns.Record) (result SyncResult, err error) {
+	zone = getDomain(zone)
+	p.logf("SyncZone %s %v", zone, logRecords(desired))
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	ctx, requests := CountRequests(ctx)
+	defer func() {
+		result.Requests = requests()
+	}()
+
+	plan, err := p.planZone(ctx, zone, desired)
+	if err != nil {
+		reportAllFailed(ctx, err, desired...)
+		return result, err
+	}
+
+	var errs batchErrors
+
+	result.Created, err = p.AppendRecords(ctx, zone, plan.Create)
+	if err != nil {
+		if errs.add(err); p.stopBatch(ctx) {
+			reportSkipped(ctx, skippedAfterFailure, plan.Update...)
+			reportSkipped(ctx, skippedAfterFailure, plan.Delete...)
+			return result, errs.err()
+		}
+	}
+
+	for i, record := range plan.Update {
+		if err := p.updateRecord(ctx, zone, record); err != nil {
+			reportFailed(ctx, record, err)
+			if errs.add(err); p.stopBatch(ctx) {
+				reportSkipped(ctx, skippedAfterFailure, plan.Update[i+1:]...)
+				reportSkipped(ctx, skippedAfterFailure, plan.Delete...)
+				return result, errs.err()
+			}
+			continue
+		}
+		reportSucceeded(ctx, record)
+		result.Updated = append(result.Updated, record)
+	}
+
+	result.Deleted, err = p.deleteRecords(ctx, zone, plan.Delete)
+	if err != nil {
+		errs.add(err)
+	}
+	return result, errs.err()
+}
+
+// resetConcurrency bounds the number of deletions ResetZone runs at once.
+const resetConcurrency = 4
+
+// ResetZone deletes every record in the zone except the SOA and apex NS
+// records namesilo manages, for instance to tear down a test zone. This is
+// destructive and can't be undone, so it does nothing unless confirm is
+// true. Deletions run a few at a time, and all are attempted even if some
+// fail; the records deleted are returned along with any failures.
+func (p *Provider) ResetZone(ctx context.Context, zone string, confirm bool) (deleted []libdns.Record, err error) {
+	zone = getDomain(zone)
+	if !confirm {
+		return nil, fmt.Errorf("ResetZone would delete every record in %s; pass confirm to proceed", zone)
+	}
+	p.logf("ResetZone %s", zone)
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	current, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	var records []libdns.Record
+	for _, record := range current {
+		if !isSystemRecord(zone, record) {
+			records = append(records, record)
+		}
+	}
+
+	failures := make([]error, len(records))
+	sem := make(chan struct{}, resetConcurrency)
+	var wg sync.WaitGroup
+	for i, record := range records {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, record libdns.Record) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := p.deleteRecord(ctx, zone, record.ID, getHostname(zone, record.Name)); err != nil {
+				failures[i] = withRecord(err, record)
+			}
+		}(i, record)
+	}
+	wg.Wait()
+
+	var errs batchErrors
+	for i, record := range records {
+		if failures[i] != nil {
+			reportFailed(ctx, record, failures[i])
+			errs.add(failures[i])
+			continue
+		}
+		reportSucceeded(ctx, record)
+		deleted = append(deleted, record)
+	}
+	return deleted, errs.err()
+}
+
+// planZone prepares the desired records as they would be sent and plans
+// the changes against the zone's current records.
+func (p *Provider) planZone(ctx context.Context, zone string, desired []libdns.Record) (SyncPlan, error) {
+	prepared := make([]libdns.Record, 0, len(desired))
+	for _, record := range desired {
+		record, err := p.normalizeRecord(zone, record)
+		if err != nil {
+			return SyncPlan{}, err
+		}
+		prepared = append(prepared, record)
+	}
+
+	current, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return SyncPlan{}, err
+	}
+
+	create, update, remove := planSync(zone, current, prepared)
+	return SyncPlan{Create: create, Update: update, Delete: remove}, nil
+}
+
+// ReplaceRecord makes record the only record of its type and name in the
+// zone. An existing record is updated in place, keeping its ID, preferably
+// one already holding the value; any others are deleted. If there is none,
+// the record is created. The record as now stored is returned.
+func (p *Provider) ReplaceRecord(ctx context.Context, zone string, record libdns.Record) (libdns.Record, error) {
+	zone = getDomain(zone)
+	p.logf("ReplaceRecord %s %v", zone, logRecords([]libdns.Record{record})[0])
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	record, err := p.normalizeRecord(zone, record)
+	if err != nil {
+		return libdns.Record{}, err
+	}
+
+	current, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return libdns.Record{}, err
+	}
+
+	var existing []libdns.Record
+	for _, c := range current {
+		if sameRRset(zone, c, record) {
+			existing = append(existing, c)
+		}
+	}
+
+	if len(existing) == 0 {
+		created, err := p.AppendRecords(ctx, zone, []libdns.Record{record})
+		if err != nil {
+			return libdns.Record{}, err
+		}
+		if len(created) > 0 {
+			return created[0], nil
+		}
+		// SkipExisting found the record created since the zone was
+		// fetched, so return it as it now stands.
+		return p.findRecord(ctx, zone, record)
+	}
+
+	keep := 0
+	for i, c := range existing {
+		if c.Value == record.Value {
+			keep = i
+			break
+		}
+	}
+	kept := existing[keep]
+	duplicates := append(existing[:keep:keep], existing[keep+1:]...)
+
+	record.ID = kept.ID
+	if !recordUnchanged(zone, kept, record) {
+		if err := p.updateRecord(ctx, zone, record); err != nil {
+			return libdns.Record{}, err
+		}
+	}
+
+	if _, err := p.deleteRecords(ctx, zone, duplicates); err != nil {
+		return record, err
+	}
+
+	return record, nil
+}
+
+// findRecord returns the record in the zone with the type, name and value
+// of record.
+func (p *Provider) findRecord(ctx context.Context, zone string, record libdns.Record) (libdns.Record, error) {
+	current, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return libdns.Record{}, err
+	}
+	for _, c := range current {
+		if sameRRset(zone, c, record) && c.Value == record.Value {
+			return c, nil
+		}
+	}
+	return libdns.Record{}, fmt.Errorf("%w: %s record %s in %s", ErrRecordNotFound, record.Type, record.Name, zone)
+}
+
+// UpdateAddressIfChanged points name in zone at ip, using an A or AAAA
+// record depending on the address family, in the manner of a dynamic DNS
+// client. Nothing is sent when the name already resolves to ip alone, so
+// polling with an unchanged address only costs one request. It reports
+// whether a change was made.
+func (p *Provider) UpdateAddressIfChanged(ctx context.Context, zone, name, ip string) (changed bool, err error) {
+	record, err := addressRecord(name, ip)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := p.GetRecordsFiltered(ctx, zone, record.Type)
+	if err != nil {
+		return false, err
+	}
+
+	var existing []libdns.Record
+	for _, c := range current {
+		if sameRRset(zone, c, record) {
+			existing = append(existing, c)
+		}
+	}
+	if len(existing) == 1 && existing[0].Value == record.Value {
+		p.debugf("UpdateAddressIfChanged: %s record %s already points at %s", record.Type, name, record.Value)
+		return false, nil
+	}
+	if len(existing) > 0 {
+		// Keep the TTL the record was given rather than resetting it.
+		record.TTL = existing[0].TTL
+	}
+
+	if _, err := p.ReplaceRecord(ctx, zone, record); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetAddresses makes the given IPs the exact set of addresses name in zone
+// resolves to, as for round-robin load balancing. A and AAAA records are
+// chosen by address family; existing ones are kept or reused where
+// possible, missing ones are created and the rest are deleted. The
+// resulting address records are returned.
+func (p *Provider) SetAddresses(ctx context.Context, zone, name string, ips []string, ttl time.Duration) ([]libdns.Record, error) {
+	zone = getDomain(zone)
+	p.logf("SetAddresses %s %s %v", zone, name, ips)
+
+	ctx, cancel := p.batchContext(ctx)
+	defer cancel()
+
+	var desired []libdns.Record
+	for _, ip := range ips {
+		record, err := addressRecord(name, ip)
+		if err != nil {
+			return nil, err
+		}
+		record.TTL, err = p.adjustTTL(ttl)
+		if err != nil {
+			return nil, err
+		}
+		desired = append(desired, record)
+	}
+
+	records, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	var current []libdns.Record
+	for _, record := range records {
+		if (record.Type == "A" || record.Type == "AAAA") && getHostname(zone, record.Name) == getHostname(zone, name) {
+			current = append(current, record)
+		}
+	}
+
+	create, update, remove := planSync(zone, current, desired)
+
+	created, err := p.AppendRecords(ctx, zone, create)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range update {
+		if err := p.updateRecord(ctx, zone, record); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := p.deleteRecords(ctx, zone, remove); err != nil {
+		return nil, err
+	}
+
+	// What's left is every current record that stayed as it was, plus the
+	// updated and created ones.
+	var result []libdns.Record
+	result = append(result, update...)
+	result = append(result, created...)
+	for _, record := range current {
+		if !containsRecordID(remove, record.ID) && !containsRecordID(update, record.ID) {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+// addressRecord returns an A or AAAA record for ip, depending on its
+// address family.
+func addressRecord(name, ip string) (libdns.Record, error) {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return libdns.Record{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+	if addr4 := addr.To4(); addr4 != nil {
+		return libdns.Record{Type: "A", Name: name, Value: addr4.String()}, nil
+	}
+	return libdns.Record{Type: "AAAA", Name: name, Value: addr.String()}, nil
+}
+
+func containsRecordID(records []libdns.Record, id string) bool {
+	_, ok := findRecordByID(records, id)
+	return ok
+}
+
+// planSync works out how to turn the current records into the desired
+// ones. Desired records are first paired with current records holding the
+// same ID, or else the same type, name and value; leftover desired records
+// then take over current records of the same type and name before new ones
+// are created. Current records left over at the end are to be removed.
+func planSync(zone string, current, desired []libdns.Record) (create, update, remove []libdns.Record) {
+	var remaining []libdns.Record
+	for _, record := range current {
+		if !isSystemRecord(zone, record) {
+			remaining = append(remaining, record)
+		}
+	}
+
+	take := func(match func(libdns.Record) bool) (libdns.Record, bool) {
+		for i, record := range remaining {
+			if match(record) {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				return record, true
+			}
+		}
+		return libdns.Record{}, false
+	}
+
+	var unmatched []libdns.Record
+	for _, record := range desired {
+		existing, ok := take(func(c libdns.Record) bool {
+			if record.ID != "" {
+				return c.ID == record.ID
+			}
+			return sameRRset(zone, c, record) && c.Value == record.Value
+		})
+		if !ok {
+			unmatched = append(unmatched, record)
+			continue
+		}
+		if !recordUnchanged(zone, existing, record) {
+			record.ID = existing.ID
+			update = append(update, record)
+		}
+	}
+
+	for _, record := range unmatched {
+		existing, ok := take(func(c libdns.Record) bool {
+			return sameRRset(zone, c, record)
+		})
+		if ok {
+			record.ID = existing.ID
+			update = append(update, record)
+		} else {
+			record.ID = ""
+			create = append(create, record)
+		}
+	}
+
+	return create, update, remaining
+}
+
+// sameRRset reports whether a and b have the same type and name.
+func sameRRset(zone string, a, b libdns.Record) bool {
+	return strings.EqualFold(a.Type, b.Type) && getHostname(zone, a.Name) == getHostname(zone, b.Name)
+}
@@ -0,0 +1,372 @@
+package namesilo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+func TestApplyTemplate(t *testing.T) {
+	template := []libdns.Record{
+		{Type: "A", Name: "@", Value: "192.0.2.1"},
+		{Type: "MX", Name: "@", Value: "mail.example.org", Priority: 10},
+		{ID: "42", Type: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=none"},
+	}
+
+	for _, zone := range []string{"example.com", "example.net"} {
+		t.Run(zone, func(t *testing.T) {
+			f := newFakeNamesilo(t, NamesiloRecord{RecordID: "1", Type: "TXT", Host: "other." + zone, Value: "kept", TTL: 3600})
+			f.domain = zone
+
+			result, err := f.provider().ApplyTemplate(context.Background(), zone, template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Created) != 3 || len(result.Updated) != 0 {
+				t.Errorf("created %d and updated %d records, want 3 created", len(result.Created), len(result.Updated))
+			}
+
+			var hosts []string
+			for _, record := range f.zone() {
+				hosts = append(hosts, record.Type+" "+record.Host)
+			}
+			sort.Strings(hosts)
+			want := []string{"A " + zone, "MX " + zone, "TXT _dmarc." + zone, "TXT other." + zone}
+			if len(hosts) != len(want) {
+				t.Fatalf("zone holds %v, want %v", hosts, want)
+			}
+			for i := range want {
+				if hosts[i] != want[i] {
+					t.Errorf("zone holds %v, want %v", hosts, want)
+					break
+				}
+			}
+			if n := f.count("dnsUpdateRecord"); n != 0 {
+				t.Errorf("sent %d updates, want the template's ID ignored", n)
+			}
+		})
+	}
+}
+
+func TestSyncZoneCountsRequests(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "TXT", "old", "stale"),
+		nsRecord("3", "TXT", "gone", "stale"),
+		nsRecord("4", "MX", "", "mail.example.com"),
+	)
+
+	result, err := f.provider().SyncZone(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.2"},
+		{Type: "MX", Name: "@", Value: "mail.example.com", TTL: time.Hour},
+		{Type: "TXT", Name: "new", Value: "fresh"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != f.count("") {
+		t.Errorf("Requests = %d, but the server received %d", result.Requests, f.count(""))
+	}
+	if len(result.Created) == 0 || len(result.Updated) == 0 || len(result.Deleted) == 0 {
+		t.Errorf("result %+v isn't a mixed reconciliation", result)
+	}
+}
+
+func TestApplyTemplateRejectsNamesOfAnotherZone(t *testing.T) {
+	f := newFakeNamesilo(t)
+	f.domain = "example.net"
+
+	_, err := f.provider().ApplyTemplate(context.Background(), "example.net", []libdns.Record{
+		{Type: "A", Name: "www.example.com", Value: "192.0.2.1"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "outside zone example.net") {
+		t.Errorf("ApplyTemplate returned %v, want an outside zone error", err)
+	}
+	if n := f.count("dnsAddRecord"); n != 0 {
+		t.Errorf("sent %d adds", n)
+	}
+}
+
+func TestReplaceRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    []NamesiloRecord
+		wantID  string
+		wantOps map[string]int
+	}{
+		{
+			"replace existing",
+			[]NamesiloRecord{nsRecord("1", "TXT", "_acme-challenge", "old")},
+			"1",
+			map[string]int{"dnsUpdateRecord": 1},
+		},
+		{
+			"create new",
+			[]NamesiloRecord{nsRecord("1", "TXT", "other", "old")},
+			"101",
+			map[string]int{"dnsAddRecord": 1},
+		},
+		{
+			"dedup multiple",
+			[]NamesiloRecord{
+				nsRecord("1", "TXT", "_acme-challenge", "old"),
+				nsRecord("2", "TXT", "_acme-challenge", "new"),
+				nsRecord("3", "TXT", "_acme-challenge", "older"),
+			},
+			"2",
+			map[string]int{"dnsDeleteRecord": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t, tt.zone...)
+
+			record, err := f.provider().ReplaceRecord(context.Background(), testZone, libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "new"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.ID != tt.wantID || record.Value != "new" {
+				t.Errorf("ReplaceRecord = %+v, want ID %s with value new", record, tt.wantID)
+			}
+			for _, op := range []string{"dnsAddRecord", "dnsUpdateRecord", "dnsDeleteRecord"} {
+				if got := f.count(op); got != tt.wantOps[op] {
+					t.Errorf("sent %d %s requests, want %d", got, op, tt.wantOps[op])
+				}
+			}
+
+			var values []string
+			for _, r := range f.zone() {
+				if r.Host == "_acme-challenge.example.com" {
+					values = append(values, r.Value)
+				}
+			}
+			if len(values) != 1 || values[0] != "new" {
+				t.Errorf("_acme-challenge holds %q, want only new", values)
+			}
+		})
+	}
+}
+
+func TestReplaceRecordSkippedAsExisting(t *testing.T) {
+	// The record appears between ReplaceRecord's listing and the append.
+	f := newFakeNamesilo(t, nsRecord("7", "TXT", "_acme-challenge", "new"))
+	f.hide("7", 1)
+	p := f.provider()
+	p.SkipExisting = true
+
+	record, err := p.ReplaceRecord(context.Background(), testZone, libdns.Record{Type: "TXT", Name: "_acme-challenge", Value: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ID != "7" {
+		t.Errorf("ReplaceRecord = %+v, want the existing record 7", record)
+	}
+	if n := f.count("dnsAddRecord"); n != 0 {
+		t.Errorf("sent %d adds", n)
+	}
+}
+
+func TestUpdateAddressIfChanged(t *testing.T) {
+	f := newFakeNamesilo(t, nsRecord("1", "A", "home", "192.0.2.1"))
+	p := f.provider()
+	ctx := context.Background()
+
+	changed, err := p.UpdateAddressIfChanged(ctx, testZone, "home", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || f.count("") != 1 {
+		t.Errorf("unchanged address: changed = %v after %d requests, want false after one listing", changed, f.count(""))
+	}
+
+	changed, err = p.UpdateAddressIfChanged(ctx, testZone, "home", "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("changed address: changed = false")
+	}
+	if zone := f.zone(); len(zone) != 1 || zone[0].RecordID != "1" || zone[0].Value != "192.0.2.2" || zone[0].TTL != 3600 {
+		t.Errorf("zone holds %+v, want record 1 updated in place with its TTL", zone)
+	}
+
+	changed, err = p.UpdateAddressIfChanged(ctx, testZone, "home", "2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || len(f.zone()) != 2 || f.zone()[1].Type != "AAAA" {
+		t.Errorf("IPv6 address: changed = %v, zone holds %+v; want an AAAA record added", changed, f.zone())
+	}
+
+	if _, err := p.UpdateAddressIfChanged(ctx, testZone, "home", "not-an-ip"); err == nil {
+		t.Error("invalid address accepted")
+	}
+}
+
+func TestSyncZoneContinueOnError(t *testing.T) {
+	for _, continueOnError := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ContinueOnError=%v", continueOnError), func(t *testing.T) {
+			f := newFakeNamesilo(t,
+				nsRecord("1", "A", "a", "192.0.2.1"),
+				nsRecord("2", "A", "b", "192.0.2.1"),
+				nsRecord("3", "A", "c", "192.0.2.1"),
+				nsRecord("4", "TXT", "gone", "stale"),
+			)
+			f.handle("dnsUpdateRecord", func(w http.ResponseWriter, r *http.Request) {
+				if r.Form.Get("rrhost") == "b" {
+					writeReply(w, "dnsUpdateRecord", codeDNSModification, "Invalid value", "")
+					return
+				}
+				f.serveDefault(w, r)
+			})
+			p := f.provider()
+			p.ContinueOnError = continueOnError
+
+			result, err := p.SyncZone(context.Background(), testZone, []libdns.Record{
+				{Type: "A", Name: "a", Value: "192.0.2.2"},
+				{Type: "A", Name: "b", Value: "192.0.2.2"},
+				{Type: "A", Name: "c", Value: "192.0.2.2"},
+			})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Value != "192.0.2.2" {
+				t.Fatalf("SyncZone returned %v, want the update of b's error", err)
+			}
+
+			wantUpdated, wantDeleted := 1, 0
+			if continueOnError {
+				wantUpdated, wantDeleted = 2, 1
+			}
+			if len(result.Updated) != wantUpdated || len(result.Deleted) != wantDeleted {
+				t.Errorf("updated %d and deleted %d records, want %d and %d",
+					len(result.Updated), len(result.Deleted), wantUpdated, wantDeleted)
+			}
+			if n := f.count("dnsDeleteRecord"); n != wantDeleted {
+				t.Errorf("sent %d deletes, want %d", n, wantDeleted)
+			}
+		})
+	}
+}
+
+func TestSetAddresses(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "A", "www", "192.0.2.2"),
+		nsRecord("3", "AAAA", "www", "2001:db8::1"),
+		nsRecord("4", "A", "mail", "192.0.2.2"),
+	)
+	p := f.provider()
+
+	result, err := p.SetAddresses(context.Background(), testZone, "www", []string{"192.0.2.1", "192.0.2.3"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var values []string
+	for _, record := range result {
+		values = append(values, record.Type+" "+record.Value)
+	}
+	sort.Strings(values)
+	if strings.Join(values, ", ") != "A 192.0.2.1, A 192.0.2.3" {
+		t.Errorf("SetAddresses = %v, want A 192.0.2.1 and A 192.0.2.3", values)
+	}
+
+	var zone []string
+	for _, record := range f.zone() {
+		zone = append(zone, record.Type+" "+record.Host+" "+record.Value)
+	}
+	sort.Strings(zone)
+	want := "A mail.example.com 192.0.2.2, A www.example.com 192.0.2.1, A www.example.com 192.0.2.3"
+	if strings.Join(zone, ", ") != want {
+		t.Errorf("zone holds %v, want %s", zone, want)
+	}
+
+	requests := f.count("")
+	if _, err := p.SetAddresses(context.Background(), testZone, "www", []string{"192.0.2.300"}, time.Hour); err == nil {
+		t.Error("SetAddresses accepted an invalid address")
+	}
+	if n := f.count(""); n != requests {
+		t.Errorf("sent %d requests for an invalid address", n-requests)
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "www", "192.0.2.1"),
+		nsRecord("2", "TXT", "orphan", "stale"),
+		nsRecord("3", "NS", "", "ns1.dnsowl.com"),
+		nsRecord("4", "MX", "", "mail.example.com"),
+	)
+
+	plan, err := f.provider().PlanSync(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.2"},
+		{Type: "MX", Name: "@", Value: "mail.example.com", TTL: time.Hour},
+		{Type: "TXT", Name: "new", Value: "fresh"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Create) != 1 || plan.Create[0].Name != "new" {
+		t.Errorf("plan creates %v, want the TXT record new", plan.Create)
+	}
+	if len(plan.Update) != 1 || plan.Update[0].ID != "1" || plan.Update[0].Value != "192.0.2.2" {
+		t.Errorf("plan updates %v, want record 1 to 192.0.2.2", plan.Update)
+	}
+	// The apex NS record is never deleted.
+	if len(plan.Delete) != 1 || plan.Delete[0].ID != "2" {
+		t.Errorf("plan deletes %v, want the orphaned record 2", plan.Delete)
+	}
+	if n, listings := f.count(""), f.count("dnsListRecords"); n != listings {
+		t.Errorf("sent %d requests besides listings", n-listings)
+	}
+}
+
+func TestResetZone(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "NS", "", "ns1.dnsowl.com"),
+		nsRecord("2", "SOA", "", "ns1.dnsowl.com. hostmaster.example.com. 1 7200 1800 1209600 3600"),
+		nsRecord("3", "A", "www", "192.0.2.1"),
+		nsRecord("4", "TXT", "a", "x"),
+		nsRecord("5", "TXT", "b", "x"),
+		nsRecord("6", "TXT", "c", "x"),
+		nsRecord("7", "NS", "sub", "ns1.other.net"),
+	)
+	p := f.provider()
+
+	if _, err := p.ResetZone(context.Background(), testZone, false); err == nil {
+		t.Error("ResetZone without confirm succeeded")
+	}
+	if n := f.count(""); n != 0 {
+		t.Fatalf("sent %d requests without confirm", n)
+	}
+
+	f.handle("dnsDeleteRecord", func(w http.ResponseWriter, r *http.Request) {
+		if r.Form.Get("rrid") == "5" {
+			writeReply(w, "dnsDeleteRecord", codeInternalError, "Internal error", "")
+			return
+		}
+		f.serveDefault(w, r)
+	})
+	deleted, err := p.ResetZone(context.Background(), testZone, true)
+	if err == nil {
+		t.Error("ResetZone didn't report the failed deletion")
+	}
+	if len(deleted) != 4 {
+		t.Errorf("ResetZone deleted %v, want 4 records", deleted)
+	}
+
+	var ids []string
+	for _, record := range f.zone() {
+		ids = append(ids, record.RecordID)
+	}
+	if got := strings.Join(ids, " "); got != "1 2 5" {
+		t.Errorf("zone holds records %s, want the SOA, apex NS and failed record 1 2 5", got)
+	}
+}
@@ -0,0 +1,115 @@
+package namesilo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AllowedTTLs are the TTL values, in ascending order, that records are
+// adjusted to before being sent to namesilo. namesilo doesn't accept TTLs
+// below one hour; 7207 seconds is its default.
+var AllowedTTLs = []time.Duration{
+	1 * time.Hour,
+	2 * time.Hour,
+	DefaultTTL,
+	4 * time.Hour,
+	8 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// TTLAuto can be used as a record TTL to explicitly request namesilo's
+// default TTL. It is sent as DefaultTTL, whereas a zero TTL leaves the
+// rrttl parameter out and lets namesilo apply its default implicitly.
+const TTLAuto time.Duration = -1
+
+// DefaultTTL is the TTL namesilo assigns when none is given. The API has
+// no way to read it, so it is hardcoded.
+const DefaultTTL = 7207 * time.Second
+
+// TTLFromSeconds returns the record TTL for a number of seconds, such as a
+// TTL given on a command line.
+func TTLFromSeconds(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// ttlSeconds returns ttl in whole seconds, the unit namesilo and zone files
+// use. It rounds to the nearest second rather than truncating, and never
+// turns a positive TTL into zero, which would mean no TTL at all.
+func ttlSeconds(ttl time.Duration) int64 {
+	seconds := int64(ttl.Round(time.Second) / time.Second)
+	if seconds == 0 && ttl > 0 {
+		return 1
+	}
+	return seconds
+}
+
+// TTLRounding controls how a record TTL that isn't one of AllowedTTLs is
+// adjusted.
+type TTLRounding int
+
+const (
+	// RoundUp uses the smallest allowed TTL not below the requested one.
+	RoundUp TTLRounding = iota
+	// RoundDown uses the largest allowed TTL not above the requested one.
+	RoundDown
+	// RoundNearest uses the closest allowed TTL, preferring the larger on
+	// a tie.
+	RoundNearest
+	// Strict rejects TTLs that aren't allowed.
+	Strict
+)
+
+// adjustTTL maps ttl onto AllowedTTLs according to p.TTLRounding. A zero
+// TTL means unset and is returned unchanged; TTLAuto becomes DefaultTTL.
+func (p *Provider) adjustTTL(ttl time.Duration) (time.Duration, error) {
+	if ttl == TTLAuto {
+		return DefaultTTL, nil
+	}
+	if ttl == 0 || len(AllowedTTLs) == 0 {
+		return ttl, nil
+	}
+	// namesilo only takes whole seconds, so a fraction of one mustn't
+	// push the TTL past an allowed value.
+	ttl = time.Duration(ttlSeconds(ttl)) * time.Second
+
+	// Find the allowed values just below and above ttl, clamping at the
+	// ends of the range.
+	lower, upper := AllowedTTLs[0], AllowedTTLs[len(AllowedTTLs)-1]
+	for _, allowed := range AllowedTTLs {
+		if allowed == ttl {
+			return ttl, nil
+		}
+		if allowed < ttl {
+			lower = allowed
+		} else {
+			upper = allowed
+			break
+		}
+	}
+
+	switch p.TTLRounding {
+	case RoundDown:
+		if lower > ttl {
+			return upper, nil
+		}
+		return lower, nil
+	case RoundNearest:
+		if ttl-lower < upper-ttl {
+			return lower, nil
+		}
+		return upper, nil
+	case Strict:
+		allowed := make([]string, len(AllowedTTLs))
+		for i, value := range AllowedTTLs {
+			allowed[i] = fmt.Sprintf("%v (%ds)", value, ttlSeconds(value))
+		}
+		return 0, fmt.Errorf("TTL %v is not one of namesilo's allowed values: %s", ttl, strings.Join(allowed, ", "))
+	default:
+		if upper < ttl {
+			return lower, nil
+		}
+		return upper, nil
+	}
+}
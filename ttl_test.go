@@ -0,0 +1,129 @@
+package namesilo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+func TestAdjustTTL(t *testing.T) {
+	tests := []struct {
+		rounding TTLRounding
+		ttl      time.Duration
+		want     time.Duration
+	}{
+		{RoundUp, 5 * time.Hour, 8 * time.Hour},
+		{RoundDown, 5 * time.Hour, 4 * time.Hour},
+		{RoundNearest, 5 * time.Hour, 4 * time.Hour},
+		{RoundNearest, 7 * time.Hour, 8 * time.Hour},
+		{RoundNearest, 6 * time.Hour, 8 * time.Hour},
+		{RoundUp, 4 * time.Hour, 4 * time.Hour},
+		{Strict, 4 * time.Hour, 4 * time.Hour},
+		{RoundUp, time.Minute, time.Hour},
+		{RoundDown, time.Minute, time.Hour},
+		{RoundUp, 48 * time.Hour, 24 * time.Hour},
+		{RoundUp, 0, 0},
+		{Strict, TTLAuto, DefaultTTL},
+	}
+	for _, tt := range tests {
+		p := &Provider{TTLRounding: tt.rounding}
+		got, err := p.adjustTTL(tt.ttl)
+		if err != nil {
+			t.Errorf("rounding %d, adjustTTL(%v): %v", tt.rounding, tt.ttl, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("rounding %d, adjustTTL(%v) = %v, want %v", tt.rounding, tt.ttl, got, tt.want)
+		}
+	}
+}
+
+func TestAdjustTTLStrict(t *testing.T) {
+	p := &Provider{TTLRounding: Strict}
+	ttl, err := p.adjustTTL(5 * time.Hour)
+	if err == nil {
+		t.Fatalf("adjustTTL(5h) = %v, want an error", ttl)
+	}
+	for _, allowed := range AllowedTTLs {
+		if want := fmt.Sprintf("%v (%ds)", allowed, ttlSeconds(allowed)); !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't list the allowed TTL %s", err, want)
+		}
+	}
+	if ttl, err := p.adjustTTL(2 * time.Hour); err != nil || ttl != 2*time.Hour {
+		t.Errorf("adjustTTL(2h) = %v, %v; want the allowed TTL unchanged", ttl, err)
+	}
+
+	f := newFakeNamesilo(t)
+	p = f.provider()
+	p.TTLRounding = Strict
+	_, err = p.AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: "www", Value: "192.0.2.1", TTL: 5 * time.Hour}})
+	if err == nil {
+		t.Error("AppendRecords accepted a TTL that isn't allowed")
+	}
+	if n := f.count("dnsAddRecord"); n != 0 {
+		t.Errorf("sent %d adds", n)
+	}
+}
+
+func TestTTLAutoIsSent(t *testing.T) {
+	f := newFakeNamesilo(t)
+	_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "TXT", Name: "auto", Value: "a", TTL: TTLAuto},
+		{Type: "TXT", Name: "unset", Value: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adds := f.received("dnsAddRecord")
+	if got := adds[0].Params.Get("rrttl"); got != "7207" {
+		t.Errorf("TTLAuto sent as rrttl %q, want 7207", got)
+	}
+	if got, ok := adds[1].Params["rrttl"]; ok {
+		t.Errorf("unset TTL sent as rrttl %q, want it left out", got)
+	}
+}
+
+func TestTTLSeconds(t *testing.T) {
+	if got := TTLFromSeconds(7207); got != DefaultTTL {
+		t.Errorf("TTLFromSeconds(7207) = %v, want %v", got, DefaultTTL)
+	}
+
+	tests := []struct {
+		ttl  time.Duration
+		want int64
+	}{
+		{0, 0},
+		{time.Hour, 3600},
+		{3600*time.Second + 400*time.Millisecond, 3600},
+		{3600*time.Second + 600*time.Millisecond, 3601},
+		{time.Millisecond, 1},
+		{1500 * time.Millisecond, 2},
+	}
+	for _, tt := range tests {
+		if got := ttlSeconds(tt.ttl); got != tt.want {
+			t.Errorf("ttlSeconds(%v) = %d, want %d", tt.ttl, got, tt.want)
+		}
+	}
+
+	p := &Provider{TTLRounding: RoundUp}
+	if ttl, err := p.adjustTTL(90 * time.Minute); err != nil || ttl != 2*time.Hour {
+		t.Errorf("adjustTTL(90m) = %v, %v; want 2h", ttl, err)
+	}
+
+	// The TTL is sent in whole seconds.
+	f := newFakeNamesilo(t)
+	_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.1", TTL: TTLFromSeconds(3600) + 300*time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.received("dnsAddRecord")[0].Params.Get("rrttl"); got != "3600" {
+		t.Errorf("sent rrttl %q, want 3600", got)
+	}
+}
@@ -0,0 +1,199 @@
+package namesilo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libdns/libdns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// NormalizeRecords returns records in the form the provider sends them:
+// types uppercased, names relative to the zone with "@" for the apex,
+// values other than TXT trimmed of surrounding whitespace and TTLs
+// adjusted according to TTLRounding. Each record is validated as well.
+// The methods writing records apply the same rules, so NormalizeRecords
+// shows how a batch will be taken, or why it will be refused.
+func (p *Provider) NormalizeRecords(zone string, records []libdns.Record) ([]libdns.Record, error) {
+	zone = getDomain(zone)
+
+	normalized := make([]libdns.Record, 0, len(records))
+	for _, record := range records {
+		record, err := p.normalizeRecord(zone, record)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, record)
+	}
+	return normalized, nil
+}
+
+// normalizeRecord applies NormalizeRecords' rules to a single record.
+func (p *Provider) normalizeRecord(zone string, record libdns.Record) (libdns.Record, error) {
+	record.Type = strings.ToUpper(strings.TrimSpace(record.Type))
+	// Checked before trimming, which would quietly drop a trailing
+	// newline or carriage return.
+	if i := strings.IndexFunc(record.Value, isControl); i >= 0 {
+		return record, fmt.Errorf("%s record %s: value contains control character %q at byte %d", record.Type, record.Name, record.Value[i], i)
+	}
+	if i := strings.IndexFunc(record.Name, isControl); i >= 0 {
+		return record, fmt.Errorf("%s record %q: name contains control character %q", record.Type, record.Name, record.Name[i])
+	}
+	if err := checkInZone(zone, record.Name); err != nil {
+		return record, err
+	}
+	record.Name = relativeName(zone, record.Name)
+	if !isTXT(record.Type) {
+		record.Value = strings.TrimSpace(record.Value)
+	}
+
+	record, err := prepareRecord(zone, record)
+	if err != nil {
+		return record, err
+	}
+	record.TTL, err = p.adjustTTL(record.TTL)
+	return record, err
+}
+
+// relativeName returns a record name relative to the zone, with "@" for
+// the apex.
+func relativeName(zone, name string) string {
+	if host := getHostname(zone, name); host != "" {
+		return host
+	}
+	return "@"
+}
+
+// prepareRecord validates record and normalizes it into the form sent to
+// namesilo, before any request is made for it.
+func prepareRecord(zone string, record libdns.Record) (libdns.Record, error) {
+	if err := checkNameLength(getFQDN(zone, record.Name)); err != nil {
+		return record, fmt.Errorf("%s record %s: %w", record.Type, record.Name, err)
+	}
+
+	switch strings.ToUpper(record.Type) {
+	case "MX":
+		return prepareMX(record)
+	case "TXT", "SPF":
+		record.Value = unquoteTXT(record.Value)
+	}
+	return record, nil
+}
+
+// checkInZone rejects a record name that lies outside zone. Names are
+// taken as getHostname takes them: one ending in a dot, or in the zone
+// itself, is fully qualified and the rest are relative to the zone, so
+// "_acme-challenge.sub" is _acme-challenge.sub.example.com. A relative name
+// ending in a top-level domain, like "www.example.net" in example.com, is
+// rejected too, as it is far more likely a fully qualified name from the
+// wrong zone than a wish for www.example.net.example.com; such a name must
+// be given fully qualified instead.
+func checkInZone(zone, name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	domain := getDomain(zone)
+
+	if absolute := strings.TrimSuffix(name, "."); absolute != name {
+		if absolute == domain || strings.HasSuffix(absolute, "."+domain) {
+			return nil
+		}
+		return fmt.Errorf("record name %s. is outside zone %s", absolute, domain)
+	}
+
+	if name == domain || strings.HasSuffix(name, "."+domain) {
+		return nil
+	}
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return nil
+	}
+	if _, icann := publicsuffix.PublicSuffix(name[i+1:]); icann {
+		return fmt.Errorf("record name %s is outside zone %s; names are relative to the zone, so write %s.%s. if that is the name meant", name, domain, name, domain)
+	}
+	return nil
+}
+
+// isTXT reports whether records of recordType hold free text, which is
+// kept unquoted and never trimmed: TXT and the obsolete SPF type.
+func isTXT(recordType string) bool {
+	return strings.EqualFold(recordType, "TXT") || strings.EqualFold(recordType, "SPF")
+}
+
+// unquoteTXT returns a TXT value with zone file quoting removed, so that
+// `"v=spf1 -all"` and `v=spf1 -all` are stored alike. A value made up of
+// several quoted character-strings is joined into one. Values that aren't
+// entirely quoted are returned unchanged.
+func unquoteTXT(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, `"`) || !strings.HasSuffix(trimmed, `"`) {
+		return value
+	}
+
+	fields, depth, err := zoneFileFields(trimmed)
+	if err != nil || depth != 0 {
+		return value
+	}
+	var b strings.Builder
+	for _, field := range fields {
+		if len(field) < 2 || field[0] != '"' || field[len(field)-1] != '"' {
+			return value
+		}
+		b.WriteString(unquoteZoneString(field))
+	}
+	return b.String()
+}
+
+// prepareMX checks that an MX record names a valid mail host and carries a
+// priority in range, and strips the host's trailing dot.
+func prepareMX(record libdns.Record) (libdns.Record, error) {
+	host := strings.TrimSuffix(strings.TrimSpace(record.Value), ".")
+	if !validHostname(host) {
+		return record, fmt.Errorf("MX record %s: invalid mail host %q", record.Name, record.Value)
+	}
+	// A priority of 0 is valid and sent as such, so only values outside
+	// the 16-bit range can be rejected.
+	if record.Priority < 0 || record.Priority > 65535 {
+		return record, fmt.Errorf("MX record %s: priority %d out of range 0-65535", record.Name, record.Priority)
+	}
+	record.Value = host
+	return record, nil
+}
+
+// checkNameLength enforces DNS's length limits on a fully qualified name:
+// 63 bytes per label and 253 characters in all, which is 255 bytes in wire
+// format.
+func checkNameLength(fqdn string) error {
+	if len(fqdn) > 253 {
+		return fmt.Errorf("name is %d characters long, over the DNS limit of 253", len(fqdn))
+	}
+	for _, label := range strings.Split(fqdn, ".") {
+		if len(label) > 63 {
+			return fmt.Errorf("label %q is %d bytes long, over the DNS limit of 63", label, len(label))
+		}
+	}
+	return nil
+}
+
+// validHostname reports whether name is a syntactically valid host name:
+// dot-separated labels of letters, digits and inner hyphens.
+func validHostname(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isControl reports whether r is an ASCII control character, such as a
+// newline, which has no place in a record and would corrupt the request.
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
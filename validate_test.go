@@ -0,0 +1,258 @@
+package namesilo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+func TestMXValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  libdns.Record
+		wantErr string
+	}{
+		{"valid", libdns.Record{Type: "MX", Name: "@", Value: "mail.example.com.", Priority: 10}, ""},
+		{"priority out of range", libdns.Record{Type: "MX", Name: "@", Value: "mail.example.com", Priority: -1}, "priority -1 out of range"},
+		{"bad hostname", libdns.Record{Type: "MX", Name: "@", Value: "mail server!", Priority: 10}, `invalid mail host "mail server!"`},
+		{"empty hostname", libdns.Record{Type: "MX", Name: "@", Priority: 10}, "invalid mail host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{tt.record})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				add := f.received("dnsAddRecord")[0]
+				if got := add.Params.Get("rrvalue"); got != "mail.example.com" {
+					t.Errorf("sent rrvalue %q, want the host without trailing dot", got)
+				}
+				if got := add.Params.Get("rrdistance"); got != "10" {
+					t.Errorf("sent rrdistance %q, want 10", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("AppendRecords returned %v, want an error containing %q", err, tt.wantErr)
+			}
+			if n := f.count("dnsAddRecord"); n != 0 {
+				t.Errorf("sent %d adds for an invalid record", n)
+			}
+		})
+	}
+}
+
+func TestCheckInZone(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"www", true},
+		{"@", true},
+		{"", true},
+		{"_acme-challenge.sub", true},
+		{"host.internal", true},
+		{"www.example.com", true},
+		{"www.example.com.", true},
+		{"example.com.", true},
+		{"WWW.EXAMPLE.COM.", true},
+		{"foo.other.com", false},
+		{"www.example.net", false},
+		{"www.example.co.uk", false},
+		{"www.other.com.", false},
+		{"host.internal.", false},
+		{"badexample.com.", false},
+	}
+	for _, tt := range tests {
+		err := checkInZone("example.com", tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkInZone(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestRecordsOutsideZone(t *testing.T) {
+	for _, name := range []string{"foo.other.com", "www.other.com."} {
+		f := newFakeNamesilo(t)
+		_, err := f.provider().SetRecords(context.Background(), testZone, []libdns.Record{{Type: "A", Name: name, Value: "192.0.2.1"}})
+		if err == nil || !strings.Contains(err.Error(), "outside zone example.com") {
+			t.Errorf("SetRecords with name %q returned %v, want an outside zone error", name, err)
+		}
+		if n := f.count("dnsAddRecord") + f.count("dnsUpdateRecord"); n != 0 {
+			t.Errorf("SetRecords with name %q sent %d writes", name, n)
+		}
+	}
+
+	f := newFakeNamesilo(t)
+	records := []libdns.Record{
+		{Type: "A", Name: "www.example.com", Value: "192.0.2.1"},
+		{Type: "TXT", Name: "_acme-challenge.sub", Value: "token"},
+	}
+	if _, err := f.provider().AppendRecords(context.Background(), testZone, records); err != nil {
+		t.Fatal(err)
+	}
+	adds := f.received("dnsAddRecord")
+	if got := adds[0].Params.Get("rrhost"); got != "www" {
+		t.Errorf("absolute name sent as rrhost %q, want www", got)
+	}
+	if got := adds[1].Params.Get("rrhost"); got != "_acme-challenge.sub" {
+		t.Errorf("relative name sent as rrhost %q, want _acme-challenge.sub", got)
+	}
+}
+
+func TestTXTQuoting(t *testing.T) {
+	for _, recordType := range []string{"TXT", "SPF"} {
+		t.Run(recordType, func(t *testing.T) {
+			f := newFakeNamesilo(t)
+			p := f.provider()
+			ctx := context.Background()
+
+			_, err := p.AppendRecords(ctx, testZone, []libdns.Record{
+				{Type: recordType, Name: "quoted", Value: `"v=spf1 include:_spf.example.net -all"`},
+				{Type: recordType, Name: "plain", Value: `v=spf1 include:_spf.example.net -all`},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			adds := f.received("dnsAddRecord")
+			if adds[0].Params.Get("rrvalue") != adds[1].Params.Get("rrvalue") {
+				t.Errorf("quoted value sent as %q, plain as %q", adds[0].Params.Get("rrvalue"), adds[1].Params.Get("rrvalue"))
+			}
+
+			// Stored quoted by someone else, the value is listed unquoted.
+			f.mu.Lock()
+			f.records[0].Value = `"v=spf1 include:_spf.example.net -all"`
+			f.mu.Unlock()
+			records, err := p.GetRecords(ctx, testZone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				if record.Value != "v=spf1 include:_spf.example.net -all" {
+					t.Errorf("%s listed with value %q", record.Name, record.Value)
+				}
+			}
+
+			for _, value := range []string{`"v=spf1 include:_spf.example.net -all"`, `v=spf1 include:_spf.example.net -all`} {
+				for _, name := range []string{"quoted", "plain"} {
+					ok, err := p.RecordMatches(ctx, testZone, libdns.Record{Type: recordType, Name: name, Value: value})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !ok {
+						t.Errorf("RecordMatches(%s, %s) = false", name, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRecordMatchesNormalizes(t *testing.T) {
+	mx := nsRecord("1", "MX", "", "mail.example.com")
+	mx.Distance = 10
+	f := newFakeNamesilo(t, mx)
+
+	ok, err := f.provider().RecordMatches(context.Background(), testZone, libdns.Record{Type: "mx", Name: "example.com.", Value: " mail.example.com. ", Priority: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("RecordMatches = false for the MX record written fully qualified")
+	}
+}
+
+func TestControlCharactersRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		record libdns.Record
+	}{
+		{"newline in value", libdns.Record{Type: "TXT", Name: "note", Value: "first\nsecond"}},
+		{"tab in value", libdns.Record{Type: "TXT", Name: "note", Value: "a\tb"}},
+		{"delete in value", libdns.Record{Type: "TXT", Name: "note", Value: "a\x7f"}},
+		{"carriage return in name", libdns.Record{Type: "A", Name: "www\r", Value: "192.0.2.1"}},
+		{"trailing newline in value", libdns.Record{Type: "A", Name: "www", Value: "192.0.2.1\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeNamesilo(t, nsRecord("1", "TXT", "note", "old"))
+			p := f.provider()
+
+			if _, err := p.AppendRecords(context.Background(), testZone, []libdns.Record{tt.record}); err == nil || !strings.Contains(err.Error(), "control character") {
+				t.Errorf("AppendRecords returned %v, want a control character error", err)
+			}
+			if _, err := p.SetRecords(context.Background(), testZone, []libdns.Record{tt.record}); err == nil || !strings.Contains(err.Error(), "control character") {
+				t.Errorf("SetRecords returned %v, want a control character error", err)
+			}
+			if n := f.count("dnsAddRecord") + f.count("dnsUpdateRecord"); n != 0 {
+				t.Errorf("sent %d writes", n)
+			}
+		})
+	}
+}
+
+func TestNameLengthLimits(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	// Three labels of 63 bytes and one of 49 in example.com make a name of
+	// exactly 253 characters.
+	long := label63 + "." + label63 + "." + label63 + "." + strings.Repeat("b", 49)
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{label63, true},
+		{label63 + "a", false},
+		{long, true},
+		{long + "b", false},
+	}
+	for _, tt := range tests {
+		f := newFakeNamesilo(t)
+		_, err := f.provider().AppendRecords(context.Background(), testZone, []libdns.Record{{Type: "TXT", Name: tt.name, Value: "x"}})
+		if (err == nil) != tt.ok {
+			t.Errorf("appending a %d character name returned %v, want success %v", len(tt.name), err, tt.ok)
+		}
+		if !tt.ok && f.count("dnsAddRecord") != 0 {
+			t.Errorf("sent a %d character name", len(tt.name))
+		}
+	}
+}
+
+func TestNormalizeRecords(t *testing.T) {
+	p := &Provider{}
+	normalized, err := p.NormalizeRecords("Example.com.", []libdns.Record{
+		{Type: " a ", Name: "@", Value: " 192.0.2.1 ", TTL: time.Minute},
+		{Type: "aaaa", Name: "", Value: "2001:db8::1", TTL: 48 * time.Hour},
+		{Type: "cname", Name: "WWW.Example.com.", Value: " example.com. ", TTL: 90 * time.Minute},
+		{Type: "txt", Name: "note.example.com", Value: " spaced "},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []libdns.Record{
+		{Type: "A", Name: "@", Value: "192.0.2.1", TTL: time.Hour},
+		{Type: "AAAA", Name: "@", Value: "2001:db8::1", TTL: 24 * time.Hour},
+		{Type: "CNAME", Name: "www", Value: "example.com.", TTL: 2 * time.Hour},
+		{Type: "TXT", Name: "note", Value: " spaced "},
+	}
+	if len(normalized) != len(want) {
+		t.Fatalf("NormalizeRecords returned %d records, want %d", len(normalized), len(want))
+	}
+	for i := range want {
+		if normalized[i] != want[i] {
+			t.Errorf("record %d normalized to %+v, want %+v", i, normalized[i], want[i])
+		}
+	}
+
+	_, err = p.NormalizeRecords(testZone, []libdns.Record{
+		{Type: "A", Name: "www", Value: "192.0.2.1"},
+		{Type: "MX", Name: "@", Value: "mail server!", Priority: 10},
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid mail host") {
+		t.Errorf("NormalizeRecords returned %v, want the MX record's validation error", err)
+	}
+}
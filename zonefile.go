@@ -0,0 +1,386 @@
+package namesilo
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// ExportZone fetches the zone's records and serializes them as an RFC 1035
+// zone file, with fully qualified owner names.
+func (p *Provider) ExportZone(ctx context.Context, zone string) (string, error) {
+	zone = getDomain(zone)
+	p.logf("ExportZone %s", zone)
+
+	records, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN %s.\n", zone)
+	for _, record := range records {
+		b.WriteString(zoneFileLine(zone, record))
+		b.WriteByte('\n')
+	}
+
+	return b.String(), nil
+}
+
+// zoneFileLine formats record as a single zone file entry.
+func zoneFileLine(zone string, record libdns.Record) string {
+	ttl := record.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	recordType := strings.ToUpper(record.Type)
+	rdata := record.Value
+	switch recordType {
+	case "TXT", "SPF":
+		rdata = quoteTXT(record.Value)
+	case "CNAME", "NS", "PTR", "ANAME":
+		rdata = absoluteName(record.Value)
+	case "MX":
+		rdata = fmt.Sprintf("%d %s", record.Priority, absoluteName(record.Value))
+	case "SRV":
+		// namesilo keeps an SRV record's priority as its distance and the
+		// weight, port and target in the value.
+		fields := strings.Fields(record.Value)
+		if len(fields) == 3 {
+			fields[2] = absoluteName(fields[2])
+		}
+		rdata = fmt.Sprintf("%d %s", record.Priority, strings.Join(fields, " "))
+	}
+
+	return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", getFQDN(zone, record.Name)+".", ttlSeconds(ttl), recordType, rdata)
+}
+
+// absoluteName adds the trailing dot that marks a zone file name as fully
+// qualified.
+func absoluteName(name string) string {
+	if name == "" || strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// quoteTXT quotes a TXT value for a zone file, escaping quotes and
+// backslashes and splitting it into character-strings of at most 255
+// bytes.
+func quoteTXT(value string) string {
+	var chunks []string
+	for len(value) > 255 {
+		chunks = append(chunks, value[:255])
+		value = value[255:]
+	}
+	chunks = append(chunks, value)
+
+	for i, chunk := range chunks {
+		chunk = strings.ReplaceAll(chunk, `\`, `\\`)
+		chunk = strings.ReplaceAll(chunk, `"`, `\"`)
+		chunks[i] = `"` + chunk + `"`
+	}
+	return strings.Join(chunks, " ")
+}
+
+// ImportZone parses a BIND zone file and makes the zone hold exactly the
+// records it describes, using SyncZone. $ORIGIN, $TTL, relative names and
+// quoted TXT strings are understood. SOA and apex NS records are skipped,
+// since namesilo manages those itself.
+func (p *Provider) ImportZone(ctx context.Context, zone string, zoneFile io.Reader) (SyncResult, error) {
+	zone = getDomain(zone)
+	p.logf("ImportZone %s", zone)
+
+	records, err := parseZoneFile(zone, zoneFile)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	var managed []libdns.Record
+	for _, record := range records {
+		if isSystemRecord(zone, record) {
+			p.logf("ImportZone: skipping %s record %s managed by namesilo", record.Type, record.Name)
+			continue
+		}
+		managed = append(managed, record)
+	}
+
+	return p.SyncZone(ctx, zone, managed)
+}
+
+// parseZoneFile reads the records in a zone file. Names are returned
+// relative to zone, with "@" for the apex.
+func parseZoneFile(zone string, r io.Reader) ([]libdns.Record, error) {
+	origin := zone
+	defaultTTL := DefaultTTL
+	var lastOwner string
+
+	var records []libdns.Record
+
+	entries, err := zoneFileEntries(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		fields := entry.fields
+		line := entry.line
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("zone file line %d: $ORIGIN without a name", line)
+			}
+			origin = strings.ToLower(strings.TrimSuffix(fields[1], "."))
+			continue
+		case "$TTL":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("zone file line %d: $TTL without a value", line)
+			}
+			ttl, err := parseZoneTTL(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("zone file line %d: %w", line, err)
+			}
+			defaultTTL = ttl
+			continue
+		case "$INCLUDE", "$GENERATE":
+			return nil, fmt.Errorf("zone file line %d: %s is not supported", line, fields[0])
+		}
+
+		owner := lastOwner
+		if !entry.continued {
+			owner = zoneFileName(origin, fields[0])
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("zone file line %d: record without owner name", line)
+		}
+		if err := checkInZone(zone, owner+"."); err != nil {
+			return nil, fmt.Errorf("zone file line %d: %w", line, err)
+		}
+		lastOwner = owner
+
+		// TTL and class may appear in either order before the type.
+		ttl := defaultTTL
+		for len(fields) > 0 {
+			if strings.EqualFold(fields[0], "IN") {
+				fields = fields[1:]
+			} else if parsed, err := parseZoneTTL(fields[0]); err == nil {
+				ttl = parsed
+				fields = fields[1:]
+			} else {
+				break
+			}
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("zone file line %d: incomplete record", line)
+		}
+
+		record := libdns.Record{
+			Type: strings.ToUpper(fields[0]),
+			Name: relativeName(zone, owner),
+			TTL:  ttl,
+		}
+
+		rdata := fields[1:]
+		switch record.Type {
+		case "TXT", "SPF":
+			var value strings.Builder
+			for _, s := range rdata {
+				value.WriteString(unquoteZoneString(s))
+			}
+			record.Value = value.String()
+		case "MX", "SRV":
+			priority, err := strconv.Atoi(rdata[0])
+			if err != nil || len(rdata) < 2 {
+				return nil, fmt.Errorf("zone file line %d: invalid %s record data", line, record.Type)
+			}
+			record.Priority = priority
+			rest := rdata[1:]
+			rest[len(rest)-1] = zoneFileName(origin, rest[len(rest)-1])
+			record.Value = strings.Join(rest, " ")
+		case "CNAME", "NS", "PTR", "ANAME":
+			record.Value = zoneFileName(origin, rdata[0])
+		default:
+			record.Value = strings.Join(rdata, " ")
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+type zoneFileEntry struct {
+	line      int
+	fields    []string
+	continued bool // the entry started with whitespace, reusing the previous owner
+}
+
+// zoneFileEntries splits a zone file into entries, stripping comments and
+// joining parenthesized multi-line entries. Quoted strings are kept as
+// single fields, quotes included.
+func zoneFileEntries(r io.Reader) ([]zoneFileEntry, error) {
+	var entries []zoneFileEntry
+	var current *zoneFileEntry
+	depth := 0
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := scanner.Text()
+
+		if depth == 0 {
+			current = &zoneFileEntry{
+				line:      lineNo,
+				continued: len(text) > 0 && (text[0] == ' ' || text[0] == '\t'),
+			}
+		}
+
+		fields, delta, err := zoneFileFields(text)
+		if err != nil {
+			return nil, fmt.Errorf("zone file line %d: %w", lineNo, err)
+		}
+		current.fields = append(current.fields, fields...)
+		depth += delta
+		if depth < 0 {
+			return nil, fmt.Errorf("zone file line %d: unbalanced parentheses", lineNo)
+		}
+
+		if depth == 0 && len(current.fields) > 0 {
+			entries = append(entries, *current)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("zone file: unterminated parentheses")
+	}
+
+	return entries, nil
+}
+
+// zoneFileFields splits one line into fields, returning the net change in
+// parenthesis depth.
+func zoneFileFields(text string) ([]string, int, error) {
+	var fields []string
+	var field strings.Builder
+	inQuotes := false
+	depth := 0
+
+	flush := func() {
+		if field.Len() > 0 {
+			fields = append(fields, field.String())
+			field.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			field.WriteByte(c)
+			field.WriteByte(text[i+1])
+			i++
+		case c == '"':
+			field.WriteByte(c)
+			inQuotes = !inQuotes
+			if !inQuotes {
+				flush()
+			}
+		case inQuotes:
+			field.WriteByte(c)
+		case c == ';':
+			flush()
+			return fields, depth, nil
+		case c == '(':
+			flush()
+			depth++
+		case c == ')':
+			flush()
+			depth--
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, 0, fmt.Errorf("unterminated quoted string")
+	}
+	flush()
+
+	return fields, depth, nil
+}
+
+// unquoteZoneString strips the quotes from a zone file character-string
+// and resolves its escapes.
+func unquoteZoneString(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// zoneFileName resolves a zone file name against origin, returning it
+// fully qualified without trailing dot.
+func zoneFileName(origin, name string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.ToLower(strings.TrimSuffix(name, "."))
+	default:
+		return strings.ToLower(name) + "." + origin
+	}
+}
+
+// parseZoneTTL parses a TTL given in seconds or with BIND's unit suffixes,
+// such as "1h30m".
+func parseZoneTTL(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+
+	var total time.Duration
+	start := 0
+	for i := 0; i < len(s); i++ {
+		unit, ok := units[s[i]|0x20]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(s[start:i], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		total += time.Duration(n) * unit
+		start = i + 1
+	}
+	if start != len(s) || total == 0 && s != "0" {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return total, nil
+}
@@ -0,0 +1,153 @@
+package namesilo
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestExportZone(t *testing.T) {
+	mx := nsRecord("4", "MX", "", "mail.example.com")
+	mx.Distance = 10
+	srv := nsRecord("7", "SRV", "_sip._tcp", "5 5060 sip.example.com")
+	srv.Distance = 20
+	f := newFakeNamesilo(t,
+		nsRecord("1", "A", "", "192.0.2.1"),
+		nsRecord("2", "AAAA", "www", "2001:db8::1"),
+		nsRecord("3", "CNAME", "blog", "hosting.example.net"),
+		mx,
+		nsRecord("5", "TXT", "", `v=spf1 include:_spf.example.net "quoted" \ -all`),
+		nsRecord("6", "TXT", "long", strings.Repeat("a", 300)),
+		srv,
+		nsRecord("8", "NS", "sub", "ns1.example.net"),
+	)
+
+	got, err := f.provider().ExportZone(context.Background(), testZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "export.zone")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("ExportZone output differs from %s:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+const sampleZoneFile = `$ORIGIN example.com.
+$TTL 2h
+@	IN	SOA	ns1.dnsowl.com. hostmaster.example.com. (
+		2024010101 ; serial
+		3600 900 604800 3600 )
+	IN	NS	ns1.dnsowl.com.
+	IN	A	192.0.2.1
+	IN	MX	10 mail
+www	1h	IN	CNAME	@
+_dmarc	IN	TXT	"v=DMARC1; " "p=none"
+$ORIGIN sub.example.com.
+host	IN	AAAA	2001:db8::1 ; comment
+`
+
+func TestParseZoneFile(t *testing.T) {
+	records, err := parseZoneFile(testZone, strings.NewReader(sampleZoneFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []libdns.Record{
+		{Type: "SOA", Name: "@", Value: "ns1.dnsowl.com. hostmaster.example.com. 2024010101 3600 900 604800 3600", TTL: 2 * time.Hour},
+		{Type: "NS", Name: "@", Value: "ns1.dnsowl.com", TTL: 2 * time.Hour},
+		{Type: "A", Name: "@", Value: "192.0.2.1", TTL: 2 * time.Hour},
+		{Type: "MX", Name: "@", Value: "mail.example.com", Priority: 10, TTL: 2 * time.Hour},
+		{Type: "CNAME", Name: "www", Value: "example.com", TTL: time.Hour},
+		{Type: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=none", TTL: 2 * time.Hour},
+		{Type: "AAAA", Name: "host.sub", Value: "2001:db8::1", TTL: 2 * time.Hour},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("parsed %d records, want %d: %v", len(records), len(want), records)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+}
+
+func TestImportZone(t *testing.T) {
+	f := newFakeNamesilo(t,
+		nsRecord("1", "NS", "", "ns1.dnsowl.com"),
+		nsRecord("2", "TXT", "stale", "removed by the import"),
+	)
+
+	result, err := f.provider().ImportZone(context.Background(), testZone, strings.NewReader(sampleZoneFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Created) != 5 || len(result.Deleted) != 1 {
+		t.Errorf("created %d and deleted %d records, want 5 and 1", len(result.Created), len(result.Deleted))
+	}
+
+	var got []string
+	for _, record := range f.zone() {
+		got = append(got, record.Type+" "+record.Host+" "+record.Value)
+	}
+	sort.Strings(got)
+	want := []string{
+		"A example.com 192.0.2.1",
+		"AAAA host.sub.example.com 2001:db8::1",
+		"CNAME www.example.com example.com",
+		"MX example.com mail.example.com",
+		"NS example.com ns1.dnsowl.com",
+		"TXT _dmarc.example.com v=DMARC1; p=none",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("zone holds:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseExportedZone(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "export.zone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := parseZoneFile(testZone, strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 8 {
+		t.Fatalf("parsed %d records from the export, want 8", len(records))
+	}
+	for _, record := range records {
+		if record.Type == "TXT" && record.Name == "long" && record.Value != strings.Repeat("a", 300) {
+			t.Errorf("long TXT value didn't survive the round trip: %q", record.Value)
+		}
+		if record.Type == "TXT" && record.Name == "@" && record.Value != `v=spf1 include:_spf.example.net "quoted" \ -all` {
+			t.Errorf("quoted TXT value didn't survive the round trip: %q", record.Value)
+		}
+	}
+}
+
+func TestParseZoneFileOutsideZone(t *testing.T) {
+	zoneFile := "$ORIGIN other.com.\nwww IN A 192.0.2.1\n"
+	_, err := parseZoneFile(testZone, strings.NewReader(zoneFile))
+	if err == nil || !strings.Contains(err.Error(), "line 2: record name www.other.com. is outside zone example.com") {
+		t.Errorf("parseZoneFile returned %v, want an outside zone error for line 2", err)
+	}
+}